@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/User/internal/pkg/Account"
+	"github.com/gin-gonic/gin"
+)
+
+// demoAccount is funded at startup so the demo page can trade right away.
+const demoAccount = "demo"
+
+func balances(c *gin.Context) {
+	accountId := requestAccount(c)
+
+	c.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"account_id": accountId,
+			"balances":   accounts.Balances(accountId),
+		},
+	})
+}
+
+// deposit credits any account, so it is only served behind adminAuth.
+func deposit(c *gin.Context) {
+	type args struct {
+		AccountId string `json:"account_id"`
+		Asset     string `json:"asset"`
+		Amount    string `json:"amount"`
+	}
+
+	var param args
+	c.BindJSON(&param)
+
+	if param.AccountId == "" || (param.Asset != baseAsset && param.Asset != quoteAsset) {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "account_id 或 asset 不正確",
+		})
+		return
+	}
+	// checked before the deposit is journaled
+	amount := string2decimal(param.Amount)
+	if !amount.IsPositive() {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": Account.ErrInvalidAmount.Error(),
+		})
+		return
+	}
+	if err := queueTicker.Deposit(param.AccountId, param.Asset, amount); err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	pushBalances(param.AccountId)
+
+	c.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"balances": accounts.Balances(param.AccountId),
+		},
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/subtle"
+
+	"github.com/User/internal/pkg/Account"
+	"github.com/User/internal/pkg/Queue"
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuth guards the admin API with the -admin_token flag. Without a token
+// the admin API is disabled and refuses every request.
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(403, gin.H{
+				"ok":    false,
+				"error": "admin API disabled, start with -admin_token",
+			})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(401, gin.H{
+				"ok":    false,
+				"error": "unauthorized",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func getRiskLimits(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": riskChecker.Limits(),
+	})
+}
+
+func setRiskLimits(c *gin.Context) {
+	limits := riskChecker.Limits()
+	if err := c.BindJSON(&limits); err != nil {
+		return
+	}
+	if err := riskChecker.SetLimits(limits); err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": riskChecker.Limits(),
+	})
+}
+
+func setTradingStatus(c *gin.Context) {
+	type args struct {
+		Status string `json:"status"`
+	}
+
+	var param args
+	c.BindJSON(&param)
+
+	if err := queueTicker.SetStatus(Queue.TradingStatus(param.Status)); err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"status": queueTicker.Status(),
+		},
+	})
+}
+
+// takeSnapshot writes an on-demand snapshot to the -snapshot_dir directory.
+func takeSnapshot(c *gin.Context) {
+	if snapshotDir == "" {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "snapshots are disabled",
+		})
+		return
+	}
+
+	path, err := writeSnapshot()
+	if err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"path":        path,
+			"journal_seq": queueTicker.JournalSeq(),
+		},
+	})
+}
+
+// createApiKey mints credentials that act for an account, on WS and REST,
+// so it is only served behind adminAuth.
+func createApiKey(c *gin.Context) {
+	type args struct {
+		AccountId string `json:"account_id"`
+	}
+
+	var param args
+	c.BindJSON(&param)
+
+	if param.AccountId == "" {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "account_id 不能為空",
+		})
+		return
+	}
+	if param.AccountId == Account.FeeAccount {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "fee account cannot have credentials",
+		})
+		return
+	}
+	credentials, err := apiKeys.Generate(param.AccountId)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": credentials,
+	})
+}
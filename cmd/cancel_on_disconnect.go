@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/User/internal/pkg/wss"
+	"github.com/gin-gonic/gin"
+)
+
+// Two ways for a client to have its orders pulled when it goes away.
+//
+// Cancel-on-disconnect: a WS session that opts in has the open orders it
+// placed canceled once its connection ends and the grace period is over,
+// unless a session of the same account opts in before then, which takes
+// the orders over:
+//
+//	{"op": "cancel_on_disconnect", "enabled": true, "grace_ms": 3000}
+//
+// The dead-man's switch, for REST clients: once armed by a heartbeat, all
+// open orders of the account are canceled when no heartbeat comes within
+// the timeout of the last one. A zero timeout disarms it. The heartbeat is
+// for the account of the credentials it is sent with, see accountAuth.
+//
+//	POST /api/heartbeat {"timeout": 30}
+//
+// Both tell the account on its orders channel what they canceled.
+const (
+	opCancelOnDisconnect = "cancel_on_disconnect"
+
+	maxGrace            = time.Minute
+	maxHeartbeatTimeout = time.Hour
+)
+
+// cancelOnDisconnectGrace is the grace period of sessions that do not give
+// one.
+var cancelOnDisconnectGrace = 5 * time.Second
+
+// wsSession is what is known of a WS session for cancel-on-disconnect.
+type wsSession struct {
+	accountId string
+
+	// The orders placed through the session, open or not.
+	orders map[string]bool
+
+	enabled bool
+	grace   time.Duration
+
+	// The pending cancel, once the connection ended.
+	timer *time.Timer
+}
+
+type wsSessions struct {
+	sessions map[string]*wsSession
+
+	sync.Mutex
+}
+
+var sessions = &wsSessions{sessions: make(map[string]*wsSession)}
+
+// get returns a session, creating it if needed. It runs locked.
+func (s *wsSessions) get(session wss.Session) *wsSession {
+	ws, ok := s.sessions[session.Id]
+	if !ok {
+		ws = &wsSession{accountId: session.AccountId, orders: make(map[string]bool)}
+		s.sessions[session.Id] = ws
+	}
+	return ws
+}
+
+// placed records an order placed through a session.
+func (s *wsSessions) placed(session wss.Session, orderId string) {
+	s.Lock()
+	defer s.Unlock()
+
+	ws := s.get(session)
+	ws.orders[orderId] = true
+	// forget the orders that are done now and then
+	if len(ws.orders)%1024 == 0 {
+		for id := range ws.orders {
+			if r, ok := orderStore.Get(id); !ok || !r.Status.Open() {
+				delete(ws.orders, id)
+			}
+		}
+	}
+}
+
+// enable turns cancel-on-disconnect on or off for a session. Turning it on
+// takes over the orders of the account's sessions waiting for their grace
+// period to end.
+func (s *wsSessions) enable(session wss.Session, enabled bool, grace time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	ws := s.get(session)
+	ws.enabled, ws.grace = enabled, grace
+	if !enabled {
+		return
+	}
+	for id, other := range s.sessions {
+		if other.accountId != session.AccountId || other.timer == nil || !other.timer.Stop() {
+			continue
+		}
+		for orderId := range other.orders {
+			ws.orders[orderId] = true
+		}
+		delete(s.sessions, id)
+	}
+}
+
+// disconnected starts the grace period of a session that opted in, and
+// forgets any other.
+func (s *wsSessions) disconnected(session wss.Session) {
+	s.Lock()
+	defer s.Unlock()
+
+	ws, ok := s.sessions[session.Id]
+	if !ok {
+		return
+	}
+	if !ws.enabled {
+		delete(s.sessions, session.Id)
+		return
+	}
+	ws.timer = time.AfterFunc(ws.grace, func() { s.expire(session.Id, ws) })
+}
+
+// expire cancels the open orders of a session whose grace period is over.
+func (s *wsSessions) expire(sessionId string, ws *wsSession) {
+	s.Lock()
+	if s.sessions[sessionId] != ws {
+		// taken over
+		s.Unlock()
+		return
+	}
+	delete(s.sessions, sessionId)
+	s.Unlock()
+
+	canceled := []string{}
+	for orderId := range ws.orders {
+		if cancelById(ws.accountId, orderId) == nil {
+			canceled = append(canceled, orderId)
+		}
+	}
+	sendPrivate(channelOrders, ws.accountId, "cancel_on_disconnect", gin.H{
+		"session":  sessionId,
+		"canceled": canceled,
+	})
+}
+
+func wsCancelOnDisconnect(session wss.Session, request []byte) (interface{}, *wss.Error) {
+	var param struct {
+		Enabled bool  `json:"enabled"`
+		GraceMs int64 `json:"grace_ms"`
+	}
+	if err := json.Unmarshal(request, &param); err != nil {
+		return nil, wsBadRequest(err)
+	}
+	grace := cancelOnDisconnectGrace
+	if param.GraceMs > 0 {
+		grace = time.Duration(param.GraceMs) * time.Millisecond
+	}
+	if param.GraceMs < 0 || grace > maxGrace {
+		return nil, &wss.Error{Code: wss.ErrBadRequest, Message: "grace_ms 超出範圍"}
+	}
+
+	sessions.enable(session, param.Enabled, grace)
+	return gin.H{
+		"session":  session.Id,
+		"enabled":  param.Enabled,
+		"grace_ms": grace.Milliseconds(),
+	}, nil
+}
+
+// deadMansSwitch holds the armed switch of each account.
+type deadMansSwitch struct {
+	timers map[string]*time.Timer
+
+	sync.Mutex
+}
+
+var heartbeats = &deadMansSwitch{timers: make(map[string]*time.Timer)}
+
+// arm (re)starts the switch of an account; a zero timeout disarms it.
+func (d *deadMansSwitch) arm(accountId string, timeout time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+
+	if timer, ok := d.timers[accountId]; ok {
+		timer.Stop()
+		delete(d.timers, accountId)
+	}
+	if timeout == 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() { d.fire(accountId, timer) })
+	d.timers[accountId] = timer
+}
+
+func (d *deadMansSwitch) fire(accountId string, timer *time.Timer) {
+	d.Lock()
+	if d.timers[accountId] != timer {
+		// rearmed
+		d.Unlock()
+		return
+	}
+	delete(d.timers, accountId)
+	d.Unlock()
+
+	sendPrivate(channelOrders, accountId, "dead_mans_switch", gin.H{
+		"canceled": cancelAll(accountId),
+	})
+}
+
+func heartbeat(c *gin.Context) {
+	type args struct {
+		Timeout int64 `json:"timeout"`
+	}
+
+	var param args
+	c.BindJSON(&param)
+
+	accountId := requestAccount(c)
+	timeout := time.Duration(param.Timeout) * time.Second
+	if timeout < 0 || timeout > maxHeartbeatTimeout {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "timeout 超出範圍",
+		})
+		return
+	}
+
+	heartbeats.arm(accountId, timeout)
+	data := gin.H{
+		"account_id": accountId,
+		"timeout":    param.Timeout,
+	}
+	if timeout > 0 {
+		data["expires_at"] = time.Now().Add(timeout).UnixMilli()
+	}
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": data,
+	})
+}
@@ -1,85 +1,178 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	_ "net/http/pprof"
 
-	"github.com/User/internal/pkg/Order"
+	"github.com/User/internal/pkg/Account"
+	"github.com/User/internal/pkg/Auth"
+	"github.com/User/internal/pkg/Depth"
+	"github.com/User/internal/pkg/Kline"
+	"github.com/User/internal/pkg/Ledger"
+	"github.com/User/internal/pkg/OrderStore"
 	"github.com/User/internal/pkg/Queue"
+	"github.com/User/internal/pkg/Risk"
+	"github.com/User/internal/pkg/Ticker"
+	"github.com/User/internal/pkg/TradeStore"
 	"github.com/User/internal/pkg/wss"
+	"github.com/User/pkg/Checksum"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
 
-var sendMsg chan []byte
 var web *gin.Engine
 var queueTicker *Queue.QueueTicker
-var recentTrade []interface{}
+var tradeStore TradeStore.Store
+var orderStore *OrderStore.Store
+var accounts *Account.Accounts
+var ledger *Ledger.Ledger
+var riskChecker *Risk.Checker
+var depthBook *Depth.Book
+
+const (
+	baseAsset  = "AA"
+	quoteAsset = "USDT"
+)
 
 func main() {
 
 	port := flag.String("port", "8080", "port")
+	demoBalance := flag.String("demo_balance", "1000000", "balance of each asset credited to the demo account")
+	feeRate := flag.String("fee_rate", "0.001", "fee charged on what each side of a trade receives")
+	adminToken := flag.String("admin_token", "", "token required in the X-Admin-Token header of admin requests, the admin API is disabled when empty")
+	demoToken := flag.String("demo_token", "", "WS login token of the demo account, generated when empty")
+	demoSecret := flag.String("demo_secret", "", "WS login signing secret of the demo account, generated when empty")
+	journalPath := flag.String("journal", "AA.journal", "command journal file, empty to disable")
+	journalSync := flag.String("journal_sync", "always", "journal fsync policy: always, interval or never")
+	flag.StringVar(&snapshotDir, "snapshot_dir", "snapshots", "directory of order book snapshots, empty to disable")
+	snapshotInterval := flag.Duration("snapshot_interval", time.Minute, "how often to snapshot the order book")
+	flag.DurationVar(&cancelOnDisconnectGrace, "cancel_on_disconnect_grace", cancelOnDisconnectGrace, "default grace period of WS cancel-on-disconnect")
+	tradeStorePath := flag.String("trade_store", "AA.trades", "trade history file, empty to keep trades in memory only")
 	flag.Parse()
 	gin.SetMode(gin.DebugMode)
 
 	//trading_engine.Debug = false
 	queueTicker = Queue.NewQueueTicker("AA")
+	depthBook = Depth.NewBook(queueTicker.Symbol)
+
+	accounts = Account.NewAccounts()
+	ledger = Ledger.NewLedger()
+	queueTicker.SetFunds(engineFunds{accounts: accounts, ledger: ledger})
+	riskChecker = Risk.NewChecker(Risk.DefaultLimits())
+
+	// accounts and the ledger are restored along with the book
+	orderStore = OrderStore.NewStore()
+	if err := restore(*journalPath, *journalSync); err != nil {
+		log.Fatal(err)
+	}
+	if rate := string2decimal(*feeRate); !rate.Equal(accounts.FeeRate()) {
+		if err := queueTicker.SetFeeRate(rate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// the demo account is funded once, not on every restart
+	if balance := string2decimal(*demoBalance); balance.IsPositive() && len(accounts.Balances(demoAccount)) == 0 {
+		queueTicker.Deposit(demoAccount, baseAsset, balance)
+		queueTicker.Deposit(demoAccount, quoteAsset, balance)
+	}
+	if snapshotDir != "" {
+		go snapshotTicker(*snapshotInterval)
+	}
 
-	recentTrade = make([]interface{}, 0)
+	apiKeys = Auth.NewKeys()
+	if *demoToken != "" || *demoSecret != "" {
+		if err := apiKeys.Add(Auth.Credentials{AccountId: demoAccount, Token: *demoToken, Secret: *demoSecret}); err != nil {
+			log.Fatal(err)
+		}
+	} else if credentials, err := apiKeys.Generate(demoAccount); err == nil {
+		log.Printf("demo account WS token %s, secret %s", credentials.Token, credentials.Secret)
+	}
+
+	if *tradeStorePath != "" {
+		store, err := TradeStore.OpenFileStore(*tradeStorePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tradeStore = store
+	} else {
+		tradeStore = TradeStore.NewMemoryStore()
+	}
+	klines = Kline.NewAggregator()
+	rolling = Ticker.NewRolling(queueTicker.Symbol)
+	if err := loadHistory(queueTicker.Symbol); err != nil {
+		log.Fatal(err)
+	}
 
 	go func() {
 		log.Println(http.ListenAndServe(":6060", nil))
 	}()
 
-	startWeb(*port)
+	startWeb(*port, *adminToken)
 }
 
-func startWeb(port string) {
+func startWeb(port, adminToken string) {
 	web = gin.New()
 	web.LoadHTMLGlob("../web/*.html")
 	web.StaticFS("/static", http.Dir("../web/static"))
 
-	sendMsg = make(chan []byte, 100)
+	wss.HHub = wss.NewHub(validTopic, channelOrders, channelFills, channelBalances)
+	wss.HHub.SetAuthenticator(wsLogin)
+	setTopicPolicies(wss.HHub)
+	handleWsOrders(wss.HHub)
+	go wss.HHub.Run()
+	publishHistory()
 
 	go pushDepth()
 	go watchTradeLog()
 
 	web.GET("/api/depth", depth)
+	web.GET("/api/depth/snapshot", depthSnapshot)
+	web.GET("/api/l3", l3Snapshot)
+	web.GET("/api/bbo", bbo)
 	web.GET("/api/trade_log", trade_log)
-	web.POST("/api/new_order", newOrder)
-	web.POST("/api/cancel_order", cancelOrder)
+	web.GET("/api/trades", trades)
+	web.GET("/api/my_trades", accountAuth, myTrades)
+	web.GET("/api/klines", klineQuery)
+	web.GET("/api/ticker/24hr", ticker24hr)
 	//web.GET("/api/test_rand", testOrder)
 
+	// account routes act for the account of the request's credentials
+	web.GET("/api/order", accountAuth, getOrder)
+	web.GET("/api/open_orders", accountAuth, openOrders)
+	web.GET("/api/order_history", accountAuth, orderHistory)
+	web.POST("/api/new_order", accountAuth, newOrder)
+	web.POST("/api/cancel_order", accountAuth, cancelOrder)
+	web.POST("/api/amend_order", accountAuth, amendOrder)
+	web.POST("/api/heartbeat", accountAuth, heartbeat)
+	web.GET("/api/balances", accountAuth, balances)
+	web.GET("/api/ledger", accountAuth, ledgerQuery)
+
+	admin := web.Group("/api/admin", adminAuth(adminToken))
+	admin.GET("/risk_limits", getRiskLimits)
+	admin.POST("/risk_limits", setRiskLimits)
+	admin.POST("/trading_status", setTradingStatus)
+	admin.POST("/snapshot", takeSnapshot)
+	admin.POST("/api_keys", createApiKey)
+	admin.GET("/ws_clients", wsClients)
+	admin.POST("/deposit", deposit)
+	admin.GET("/ledger/check", ledgerCheck)
+
 	web.GET("/demo", func(c *gin.Context) {
 		c.HTML(200, "demo.html", nil)
 	})
 
 	//websocket
 	{
-		wss.HHub = wss.NewHub()
-		go wss.HHub.Run()
-		go func() {
-			for {
-				select {
-				case data := <-sendMsg:
-					wss.HHub.Send(data)
-				default:
-					time.Sleep(time.Duration(100) * time.Millisecond)
-				}
-			}
-		}()
-
 		web.GET("/ws", wss.ServeWs)
+		web.GET("/sse", wss.ServeSSE)
+		web.GET("/poll", wss.ServePoll)
 		web.GET("/pong", func(c *gin.Context) {
 			c.JSON(200, gin.H{
 				"message": "pong",
@@ -96,16 +189,60 @@ func depth(c *gin.Context) {
 	if limitInt <= 0 || limitInt > 100 {
 		limitInt = 10
 	}
-	a := queueTicker.GetAskDepth(limitInt)
-	b := queueTicker.GetBidDepth(limitInt)
+	step := queueTicker.TickSize()
+	if c.Query("step") != "" {
+		step = string2decimal(c.Query("step"))
+	}
+	a, err := queueTicker.GetAskDepthStep(limitInt, step)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": fmt.Sprintf("%v, use one of %v", err, queueTicker.DepthSteps()),
+		})
+		return
+	}
+	b, _ := queueTicker.GetBidDepthStep(limitInt, step)
+
+	c.JSON(200, gin.H{
+		"ask":      a,
+		"bid":      b,
+		"step":     step,
+		"checksum": Checksum.Compute(b, a),
+	})
+}
+
+// depthSnapshot is the full book matching the depth_update feed. Updates with
+// a last_update_id at or below its last_update_id are already included.
+func depthSnapshot(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
 
 	c.JSON(200, gin.H{
-		"ask": a,
-		"bid": b,
+		"ok":   true,
+		"data": depthBook.Snapshot(limit),
+	})
+}
+
+// l3Snapshot lists every resting order. The l3 feed continues from its seq.
+func l3Snapshot(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": queueTicker.BookSnapshot(),
+	})
+}
+
+func bbo(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": queueTicker.BBO(),
 	})
 }
 
 func trade_log(c *gin.Context) {
+	recentTrade := []gin.H{}
+	for _, trade := range tradeStore.Recent(queueTicker.Symbol, 10) {
+		recentTrade = append(recentTrade, tradeLogView(trade))
+	}
+
 	c.JSON(200, gin.H{
 		"ok": true,
 		"data": gin.H{
@@ -114,47 +251,71 @@ func trade_log(c *gin.Context) {
 	})
 }
 
-func sendMessage(tag string, data interface{}) {
-	msg := gin.H{
-		"tag":  tag,
-		"data": data,
+// tradeLogView is the trade format of the trade_log API and the trade message.
+func tradeLogView(trade TradeStore.Trade) gin.H {
+	return gin.H{
+		"TradeId":       trade.TradeId,
+		"TradePrice":    Queue.FormatDecimal2String(trade.Price, 4),
+		"TradeAmount":   Queue.FormatDecimal2String(trade.Amount, 4),
+		"TradeQuantity": Queue.FormatDecimal2String(trade.Quantity, 4),
+		"TradeTime":     trade.Time,
+		"AskOrderId":    trade.AskOrderId,
+		"BidOrderId":    trade.BidOrderId,
 	}
-	msgByte, _ := json.Marshal(msg)
-	sendMsg <- []byte(msgByte)
+}
+
+// sendMessage publishes data under tag to the WS clients subscribed to topic.
+func sendMessage(topic, tag string, data interface{}) {
+	sendVersionedMessage(topic, tag, 0, data)
+}
+
+// sendVersionedMessage publishes data that is the state at version; it is
+// not sent again while version does not move, see wss.Message.
+func sendVersionedMessage(topic, tag string, version uint64, data interface{}) {
+	wss.HHub.Publish(wss.Message{Topic: topic, Tag: tag, Version: version, Data: data})
 }
 
 func watchTradeLog() {
+	bookEvents := queueTicker.BookEvents(1000)
+	// events after the snapshot continue it
+	depthBook.Load(queueTicker.BookSnapshot())
+	bboEvents := queueTicker.BBOEvents(100)
+	tickerTimer := time.NewTicker(tickerInterval)
 	for {
 		select {
-		case log, ok := <-queueTicker.ChTradeResult:
+		case result, ok := <-queueTicker.ChTradeResult:
 			if ok {
-				//
-
-				relog := gin.H{
-					"TradePrice":    Queue.FormatDecimal2String(log.TradePrice, 4),
-					"TradeAmount":   Queue.FormatDecimal2String(log.TradeAmount, 4),
-					"TradeQuantity": Queue.FormatDecimal2String(log.TradeQuantity, 4),
-					"TradeTime":     log.TradeTime,
-					"AskOrderId":    log.AskOrderId,
-					"BidOrderId":    log.BidOrderId,
-				}
-				sendMessage("trade", relog)
-
-				if len(recentTrade) >= 10 {
-					recentTrade = recentTrade[1:]
-				}
-				recentTrade = append(recentTrade, relog)
-
-				//latest price
-				sendMessage("latest_price", gin.H{
-					"latest_price": Queue.FormatDecimal2String(log.TradePrice, 4),
-				})
-
+				settleTrade(result)
 			}
 		case cancelOrderId := <-queueTicker.ChCancelResult:
-			sendMessage("cancel_order", gin.H{
-				"OrderId": cancelOrderId,
-			})
+			settlePending()
+			orderStore.Close(cancelOrderId, OrderStore.StatusCanceled, time.Now().UnixNano())
+			if r, ok := orderStore.Get(cancelOrderId); ok {
+				pushOrder(cancelOrderId)
+				pushBalances(r.AccountId)
+				sendPrivate(channelOrders, r.AccountId, "cancel_order", gin.H{
+					"order_id": cancelOrderId,
+				})
+			}
+		case expireOrderId := <-queueTicker.ChExpireResult:
+			settlePending()
+			orderStore.Close(expireOrderId, OrderStore.StatusExpired, time.Now().UnixNano())
+			if r, ok := orderStore.Get(expireOrderId); ok {
+				pushOrder(expireOrderId)
+				pushBalances(r.AccountId)
+				sendPrivate(channelOrders, r.AccountId, "expire_order", gin.H{
+					"order_id": expireOrderId,
+				})
+			}
+		case event := <-bookEvents:
+			depthBook.Apply(event)
+			sendVersionedMessage(topic(topicL3), "l3", event.Seq, event)
+		case event := <-bboEvents:
+			sendVersionedMessage(topic(topicBBO), "bbo", event.Seq, event)
+			// the ticker carries the best bid and ask too
+			pushTicker()
+		case <-tickerTimer.C:
+			pushTicker()
 		default:
 			time.Sleep(time.Duration(100) * time.Millisecond)
 		}
@@ -162,99 +323,109 @@ func watchTradeLog() {
 	}
 }
 
-func pushDepth() {
+// settlePending books the trades already sent by the engine. It sends the
+// fills of an order before its cancel or expiry, so booking them first keeps
+// the order's history in the order things happened.
+func settlePending() {
 	for {
-		ask := queueTicker.GetAskDepth(10)
-		bid := queueTicker.GetBidDepth(10)
-
-		sendMessage("depth", gin.H{
-			"ask": ask,
-			"bid": bid,
-		})
-
-		time.Sleep(time.Duration(150) * time.Millisecond)
+		select {
+		case result := <-queueTicker.ChTradeResult:
+			settleTrade(result)
+		default:
+			return
+		}
 	}
 }
 
-func newOrder(c *gin.Context) {
-	type args struct {
-		OrderId   string `json:"order_id"`
-		OrderType string `json:"order_type"`
-		PriceType string `json:"price_type"`
-		Price     string `json:"price"`
-		Quantity  string `json:"quantity"`
-		Amount    string `json:"amount"`
+// settleTrade books a trade the engine has settled: order fills, trade store
+// and market data.
+func settleTrade(result Queue.TradeResult) {
+	now := time.Now().UnixNano()
+	orderStore.Fill(result.AskOrderId, result.TradeQuantity, result.TradePrice, now)
+	orderStore.Fill(result.BidOrderId, result.TradeQuantity, result.TradePrice, now)
+
+	pushFills(result)
+	pushOrder(result.AskOrderId)
+	pushOrder(result.BidOrderId)
+	pushBalances(result.AskAccountId)
+	if result.BidAccountId != result.AskAccountId {
+		pushBalances(result.BidAccountId)
 	}
 
-	var param args
-	c.BindJSON(&param)
+	trade, err := tradeStore.Append(TradeStore.Trade{
+		TradeId:      result.TradeId,
+		Symbol:       result.Symbol,
+		AskOrderId:   result.AskOrderId,
+		BidOrderId:   result.BidOrderId,
+		AskAccountId: result.AskAccountId,
+		BidAccountId: result.BidAccountId,
+		Price:        result.TradePrice,
+		Quantity:     result.TradeQuantity,
+		Amount:       result.TradeAmount,
+		Time:         result.TradeTime,
+	})
+	if err != nil {
+		log.Printf("store %s: %v", result.TradeId, err)
+	}
 
-	orderId := uuid.NewString()
-	param.OrderId = orderId
-	price := string2decimal(param.Price)
-	quantity := string2decimal(param.Quantity)
-
-	var pt Order.PriceType
-	if param.PriceType == "market" {
-		param.Price = "0"
-		pt = Order.PriceMarket
-		if param.Quantity != "" {
-			pt = Order.PriceMarket
-			//市价按数量买入资产时，需要用户账户所有可用资产数量，测试默认100块
-			param.Amount = "100"
-			if quantity.Cmp(decimal.NewFromFloat(100000000)) > 0 || quantity.Cmp(decimal.Zero) <= 0 {
-				c.JSON(200, gin.H{
-					"ok":    false,
-					"error": "數量必須大於0，且不能超過 100000000",
-				})
-				return
-			}
-		}
+	sendMessage(topic(topicTrade), "trade", tradeLogView(trade))
+	for _, candle := range klines.Add(result.Symbol, result.TradePrice, result.TradeQuantity, result.TradeAmount, result.TradeTime) {
+		sendMessage(topic(topicKline, candle.Interval), "kline", candle)
+	}
+	rolling.Add(result.TradePrice, result.TradeQuantity, result.TradeAmount, result.TradeTime)
+	pushTicker()
+}
 
-		if queueTicker.AskLen() == 0 {
-			c.JSON(200, gin.H{
-				"ok":    false,
-				"error": "未有人掛賣訂單",
-			})
-			return
-		}
-	} else {
-		pt = Order.PriceLimit
-		param.Amount = "0"
-		if price.Cmp(decimal.NewFromFloat(100000000)) > 0 || price.Cmp(decimal.Zero) < 0 {
-			c.JSON(200, gin.H{
-				"ok":    false,
-				"error": "價格必須大於等於0，且不能超過 100000000",
+// pushDepth sends the top of the book every 150ms: on depth:<symbol> at the
+// tick size, and on depth:<symbol>:<step> for every coarser step. The levels
+// the book events changed in the meantime go out as a depth_update.
+func pushDepth() {
+	for {
+		// read before the depth, so a change in between is sent next time
+		seq := queueTicker.BookSeq()
+		for i, step := range queueTicker.DepthSteps() {
+			ask, _ := queueTicker.GetAskDepthStep(10, step)
+			bid, _ := queueTicker.GetBidDepthStep(10, step)
+
+			t := topic(topicDepth)
+			if i > 0 {
+				t = topic(topicDepth, step.String())
+			}
+			sendVersionedMessage(t, "depth", seq, gin.H{
+				"ask":      ask,
+				"bid":      bid,
+				"step":     step,
+				"checksum": Checksum.Compute(bid, ask),
 			})
-			return
 		}
-		if quantity.Cmp(decimal.NewFromFloat(100000000)) > 0 || quantity.Cmp(decimal.Zero) <= 0 {
-			c.JSON(200, gin.H{
-				"ok":    false,
-				"error": "數量必須大於0，且不能超過 100000000",
-			})
-			return
+		if update, ok := depthBook.Flush(); ok {
+			sendVersionedMessage(topic(topicDepthUpdate), "depth_update", update.LastUpdateId, update)
 		}
+
+		time.Sleep(time.Duration(150) * time.Millisecond)
 	}
+}
 
-	if strings.ToLower(param.OrderType) == "ask" {
-		param.OrderId = fmt.Sprintf("a-%s", orderId)
-		item := Order.NewOrderItem(pt, Order.OrderSell, param.OrderId, string2decimal(param.Price), string2decimal(param.Quantity), string2decimal(param.Amount), time.Now().UnixNano())
-		queueTicker.ChOrder <- *item
+func newOrder(c *gin.Context) {
+	var param orderRequest
+	c.BindJSON(&param)
+	param.AccountId = requestAccount(c)
 
-	} else {
-		param.OrderId = fmt.Sprintf("b-%s", orderId)
-		item := Order.NewOrderItem(pt, Order.OrderBuy, param.OrderId, string2decimal(param.Price), string2decimal(param.Quantity), string2decimal(param.Amount), time.Now().UnixNano())
-		queueTicker.ChOrder <- *item
+	if rejection := placeOrder(&param); rejection != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"code":  rejection.Code,
+			"error": rejection.Message,
+		})
+		return
 	}
 
-	go sendMessage("new_order", param)
-
 	c.JSON(200, gin.H{
 		"ok": true,
 		"data": gin.H{
-			"ask_len": queueTicker.AskLen(),
-			"bid_len": queueTicker.BidLen(),
+			"order_id": param.OrderId,
+			"ask_len":  queueTicker.AskLen(),
+			"bid_len":  queueTicker.BidLen(),
 		},
 	})
 }
@@ -303,13 +474,36 @@ func cancelOrder(c *gin.Context) {
 		c.Abort()
 		return
 	}
-	if strings.HasPrefix(param.OrderId, "a-") {
-		queueTicker.CancelOrder(Order.OrderSell, param.OrderId)
-	} else {
-		queueTicker.CancelOrder(Order.OrderBuy, param.OrderId)
+	if rejection := cancelById(requestAccount(c), param.OrderId); rejection != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": rejection.Message,
+		})
+		return
 	}
 
-	go sendMessage("cancel_order", param)
+	c.JSON(200, gin.H{
+		"ok": true,
+	})
+}
+
+func amendOrder(c *gin.Context) {
+	var param amendRequest
+	c.BindJSON(&param)
+	param.AccountId = requestAccount(c)
+
+	if param.OrderId == "" {
+		c.Abort()
+		return
+	}
+	if rejection := placeAmend(&param); rejection != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"code":  rejection.Code,
+			"error": rejection.Message,
+		})
+		return
+	}
 
 	c.JSON(200, gin.H{
 		"ok": true,
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/User/internal/pkg/Account"
+	"github.com/User/internal/pkg/Ledger"
+	"github.com/User/internal/pkg/Order"
+	"github.com/User/internal/pkg/Queue"
+	"github.com/User/internal/pkg/Snapshot"
+	"github.com/shopspring/decimal"
+)
+
+// settling is held while a trade is settled and its postings recorded, so a
+// ledger check never sees one without the other.
+var settling sync.Mutex
+
+// engineFunds holds and settles the funds of queueTicker's orders in
+// accounts, and records every settlement in ledger. The engine calls it as
+// it applies and replays each command, and saves both in its snapshots.
+type engineFunds struct {
+	accounts *Account.Accounts
+	ledger   *Ledger.Ledger
+}
+
+func (f engineFunds) Hold(order Order.Order) error {
+	return f.accounts.Hold(order, baseAsset, quoteAsset)
+}
+
+func (f engineFunds) Amend(amend Order.Order) error {
+	return f.accounts.Amend(amend.OrderId, amend.AccountId, amend.Price, amend.Quantity)
+}
+
+func (f engineFunds) Settle(trade Queue.TradeResult) (decimal.Decimal, decimal.Decimal, error) {
+	settling.Lock()
+	defer settling.Unlock()
+
+	s, err := f.accounts.Settle(trade.AskOrderId, trade.BidOrderId, trade.TradeQuantity, trade.TradeAmount)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	f.ledger.Record(trade.TradeId, trade.TradeTime, s)
+	return s.BuyerFee, s.SellerFee, nil
+}
+
+func (f engineFunds) Release(orderId string) {
+	f.accounts.Release(orderId)
+}
+
+func (f engineFunds) Deposit(accountId, asset string, amount decimal.Decimal) error {
+	return f.accounts.Deposit(accountId, asset, amount)
+}
+
+func (f engineFunds) SetFeeRate(rate decimal.Decimal) {
+	f.accounts.SetFeeRate(rate)
+}
+
+func (f engineFunds) Save(s *Snapshot.Snapshot) {
+	s.Accounts = f.accounts.State()
+	s.Postings = f.ledger.Postings()
+}
+
+func (f engineFunds) Load(s Snapshot.Snapshot) {
+	f.accounts.Restore(s.Accounts)
+	f.ledger.Restore(s.Postings)
+}
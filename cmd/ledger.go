@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ledgerQuery(c *gin.Context) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+
+	c.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"postings": ledger.Query(requestAccount(c), from, to),
+		},
+	})
+}
+
+// ledgerCheck verifies the ledger invariants and reconciles every account
+// balance with its deposits and ledger postings.
+func ledgerCheck(c *gin.Context) {
+	settling.Lock()
+	err := ledger.Check(accounts)
+	settling.Unlock()
+	if err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	total, _ := accounts.Totals()
+	c.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"totals": total,
+		},
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/User/internal/pkg/Kline"
+	"github.com/User/internal/pkg/Ticker"
+	"github.com/User/internal/pkg/TradeStore"
+	"github.com/gin-gonic/gin"
+)
+
+var klines *Kline.Aggregator
+var rolling *Ticker.Rolling
+
+// tickerInterval is how often the ticker is pushed while neither trades nor
+// the BBO change it, so its 24h window keeps rolling.
+const tickerInterval = time.Second
+
+// loadHistory rebuilds the candles and the 24h ticker of symbol from the
+// trade history, oldest trade first.
+func loadHistory(symbol string) error {
+	history := make([]TradeStore.Trade, 0)
+	q := TradeStore.Query{Symbol: symbol, Limit: TradeStore.MaxLimit}
+	for {
+		page, err := tradeStore.Query(q)
+		if err != nil {
+			return err
+		}
+		history = append(history, page.Trades...)
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		t := history[i]
+		klines.Add(t.Symbol, t.Price, t.Quantity, t.Amount, t.Time)
+		rolling.Add(t.Price, t.Quantity, t.Amount, t.Time)
+	}
+	return nil
+}
+
+// publishHistory publishes the last trades and the current candles and
+// ticker, so the WS snapshots have them before the first trade.
+func publishHistory() {
+	for _, trade := range tradeStore.Recent(queueTicker.Symbol, tradeSnapshot) {
+		sendMessage(topic(topicTrade), "trade", tradeLogView(trade))
+	}
+	for _, interval := range Kline.Intervals {
+		if candle, ok := klines.Current(queueTicker.Symbol, interval); ok {
+			sendMessage(topic(topicKline, interval.Name), "kline", candle)
+		}
+	}
+	pushTicker()
+}
+
+func klineQuery(c *gin.Context) {
+	interval, ok := Kline.ParseInterval(c.Query("interval"))
+	if !ok {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "unknown interval",
+		})
+		return
+	}
+	symbol := c.Query("symbol")
+	if symbol == "" {
+		symbol = queueTicker.Symbol
+	}
+	start, _ := strconv.ParseInt(c.Query("start"), 10, 64)
+	end, _ := strconv.ParseInt(c.Query("end"), 10, 64)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": klines.Query(symbol, interval, start, end, limit),
+	})
+}
+
+// tickerStats is the current 24h ticker with the best bid and ask of the book.
+func tickerStats() Ticker.Stats {
+	stats := rolling.Stats(time.Now().Unix())
+	bbo := queueTicker.BBO()
+	stats.BestBid, stats.BestBidQuantity = bbo.BidPrice, bbo.BidQuantity
+	stats.BestAsk, stats.BestAskQuantity = bbo.AskPrice, bbo.AskQuantity
+	return stats
+}
+
+func pushTicker() {
+	sendMessage(topic(topicTicker), "ticker", tickerStats())
+}
+
+func ticker24hr(c *gin.Context) {
+	if symbol := c.Query("symbol"); symbol != "" && symbol != queueTicker.Symbol {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "unknown symbol",
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": tickerStats(),
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/User/internal/pkg/Account"
+	"github.com/User/internal/pkg/Order"
+	"github.com/User/internal/pkg/OrderStore"
+	"github.com/User/internal/pkg/Queue"
+	"github.com/User/internal/pkg/Risk"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// orderRequest is a new order as sent to the REST and WS APIs. OrderId is
+// filled in once the order is accepted.
+type orderRequest struct {
+	OrderId   string `json:"order_id"`
+	AccountId string `json:"account_id,omitempty"`
+	OrderType string `json:"order_type"`
+	PriceType string `json:"price_type"`
+	Price     string `json:"price"`
+	Quantity  string `json:"quantity"`
+	Amount    string `json:"amount"`
+	TTL       int64  `json:"ttl"`
+}
+
+// amendRequest is an amend as sent to the REST and WS APIs.
+type amendRequest struct {
+	OrderId   string `json:"order_id"`
+	AccountId string `json:"account_id,omitempty"`
+	Price     string `json:"price"`
+	Quantity  string `json:"quantity"`
+}
+
+// placeOrder builds an order from a request and submits it, then tells the
+// owner. Order ids are only ever sent to their owner.
+func placeOrder(param *orderRequest) *Risk.Rejection {
+	orderId := uuid.NewString()
+
+	var pt Order.PriceType
+	if param.PriceType == "market" {
+		param.Price = "0"
+		pt = Order.PriceMarket
+		if strings.ToLower(param.OrderType) != "ask" && string2decimal(param.Amount).Cmp(decimal.Zero) <= 0 {
+			//市价按数量买入资产时，使用用户账户所有可用资金作为预算
+			param.Amount = accounts.Available(param.AccountId, quoteAsset).String()
+		}
+	} else {
+		pt = Order.PriceLimit
+		param.Amount = "0"
+	}
+
+	var item *Order.Order
+	if strings.ToLower(param.OrderType) == "ask" {
+		param.OrderId = fmt.Sprintf("a-%s", orderId)
+		item = Order.NewOrderItem(pt, Order.OrderSell, param.OrderId, param.AccountId, string2decimal(param.Price), string2decimal(param.Quantity), string2decimal(param.Amount), time.Now().UnixNano())
+	} else {
+		param.OrderId = fmt.Sprintf("b-%s", orderId)
+		item = Order.NewOrderItem(pt, Order.OrderBuy, param.OrderId, param.AccountId, string2decimal(param.Price), string2decimal(param.Quantity), string2decimal(param.Amount), time.Now().UnixNano())
+	}
+	if param.TTL > 0 {
+		item.ExpireTime = item.CreateTime + param.TTL*int64(time.Second)
+	}
+
+	if rejection := submitOrder(*item); rejection != nil {
+		return rejection
+	}
+
+	pushOrder(param.OrderId)
+	pushBalances(param.AccountId)
+	sendPrivate(channelOrders, param.AccountId, "new_order", param)
+	return nil
+}
+
+// orderSide is the side of an order, which its id starts with.
+func orderSide(orderId string) Order.OrderType {
+	if strings.HasPrefix(orderId, "a-") {
+		return Order.OrderSell
+	}
+	return Order.OrderBuy
+}
+
+// cancelById cancels a resting order of an account. The owner gets the
+// cancel_order message once the engine reports the cancel, see watchTradeLog.
+func cancelById(accountId, orderId string) *Risk.Rejection {
+	if r, ok := orderStore.Get(orderId); !ok || r.AccountId != accountId {
+		return Risk.Reject(Risk.CodeUnknownOrder, "訂單不存在")
+	}
+	if !queueTicker.CancelOrder(orderSide(orderId), orderId) {
+		return Risk.Reject(Risk.CodeUnknownOrder, "訂單不存在")
+	}
+	return nil
+}
+
+// cancelAll cancels every resting order of an account and returns their ids.
+func cancelAll(accountId string) []string {
+	canceled := []string{}
+	for _, order := range queueTicker.Orders() {
+		if order.AccountId == accountId && cancelById(accountId, order.OrderId) == nil {
+			canceled = append(canceled, order.OrderId)
+		}
+	}
+	return canceled
+}
+
+// placeAmend submits an amend, then tells the owner.
+func placeAmend(param *amendRequest) *Risk.Rejection {
+	amend := Order.NewOrderItem(Order.PriceLimit, orderSide(param.OrderId), param.OrderId, param.AccountId, string2decimal(param.Price), string2decimal(param.Quantity), decimal.Zero, time.Now().UnixNano())
+	if rejection := submitAmend(*amend); rejection != nil {
+		return rejection
+	}
+
+	pushOrder(param.OrderId)
+	pushBalances(param.AccountId)
+	sendPrivate(channelOrders, param.AccountId, "amend_order", param)
+	return nil
+}
+
+// submitOrder is the single path every new order takes into the matching
+// engine: pre-trade risk checks, then the engine, which holds the order's
+// funds before it adds the order to the book.
+func submitOrder(item Order.Order) *Risk.Rejection {
+	if queueTicker.Status() != Queue.StatusTrading {
+		return Risk.Reject(Risk.CodeTradingHalted, "交易已暫停")
+	}
+	if item.PriceType == Order.PriceMarket && queueTicker.AskLen() == 0 {
+		return Risk.Reject(Risk.CodeNoLiquidity, "未有人掛賣訂單")
+	}
+
+	ctx := Risk.Context{
+		LastPrice:  queueTicker.LatestPrice(),
+		OpenOrders: accounts.OpenOrders(item.AccountId, baseAsset, quoteAsset),
+		Position:   riskPosition(item.AccountId, ""),
+	}
+	if rejection := riskChecker.Check(item, ctx); rejection != nil {
+		return rejection
+	}
+
+	// the record goes first, as the order's fills are reported as soon as
+	// the engine has it
+	orderStore.Add(OrderStore.NewRecord(queueTicker.Symbol, item))
+	if err := queueTicker.PushNewOrder(item); err != nil {
+		orderStore.Close(item.OrderId, OrderStore.StatusRejected, time.Now().UnixNano())
+		return engineRejection(err)
+	}
+	return nil
+}
+
+// engineRejection describes why the engine refused an order or an amend.
+func engineRejection(err error) *Risk.Rejection {
+	switch err {
+	case Queue.ErrHalted:
+		return Risk.Reject(Risk.CodeTradingHalted, "交易已暫停")
+	case Queue.ErrUnknownOrder, Account.ErrUnknownHold:
+		return Risk.Reject(Risk.CodeUnknownOrder, "訂單不存在")
+	case Account.ErrInsufficientFunds, Account.ErrDuplicateHold:
+		return Risk.Reject(Risk.CodeInsufficientFunds, err.Error())
+	}
+	log.Printf("engine: %v", err)
+	return Risk.Reject(Risk.CodeEngineError, err.Error())
+}
+
+// riskPosition is the base an account holds plus what its open bids would
+// buy, leaving out the order amendedId whose new quantity is being checked.
+func riskPosition(accountId, amendedId string) decimal.Decimal {
+	balance := accounts.Balances(accountId)[baseAsset]
+	position := balance.Available.Add(balance.Held)
+	for orderId, quantity := range accounts.OpenBids(accountId, baseAsset, quoteAsset) {
+		if orderId != amendedId {
+			position = position.Add(quantity)
+		}
+	}
+	return position
+}
+
+// submitAmend checks an amended order before handing it to the matching
+// engine, which resizes its hold along with the order. amend carries the
+// order id, side and account and the new price and remaining quantity.
+func submitAmend(amend Order.Order) *Risk.Rejection {
+	if queueTicker.Status() != Queue.StatusTrading {
+		return Risk.Reject(Risk.CodeTradingHalted, "交易已暫停")
+	}
+	ctx := Risk.Context{
+		LastPrice: queueTicker.LatestPrice(),
+		// the amended order is already one of the open orders
+		OpenOrders: accounts.OpenOrders(amend.AccountId, baseAsset, quoteAsset) - 1,
+		Position:   riskPosition(amend.AccountId, amend.OrderId),
+	}
+	if rejection := riskChecker.Check(amend, ctx); rejection != nil {
+		return rejection
+	}
+
+	if err := queueTicker.AmendOrder(amend); err != nil {
+		return engineRejection(err)
+	}
+	orderStore.Amend(amend.OrderId, amend.Price, amend.Quantity, amend.CreateTime)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/User/internal/pkg/OrderStore"
+	"github.com/gin-gonic/gin"
+)
+
+// getOrder returns an order of the account a request was authenticated for.
+func getOrder(c *gin.Context) {
+	id := c.Query("id")
+	record, ok := orderStore.Get(id)
+	if !ok || record.AccountId != requestAccount(c) {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": "訂單不存在",
+		})
+		return
+	}
+
+	// the book is authoritative for what is still resting
+	for _, order := range queueTicker.Orders() {
+		if order.OrderId == id {
+			record.Price = order.Price
+			record.RemainingQuantity = order.Quantity
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": record,
+	})
+}
+
+// openOrders lists exactly the orders of an account resting in the book,
+// with the fill state kept by the order store.
+func openOrders(c *gin.Context) {
+	symbol := c.Query("symbol")
+	account := requestAccount(c)
+
+	res := []OrderStore.Record{}
+	if symbol == "" || symbol == queueTicker.Symbol {
+		for _, order := range queueTicker.Orders() {
+			if order.AccountId != account {
+				continue
+			}
+			record, ok := orderStore.Get(order.OrderId)
+			if !ok {
+				record = OrderStore.NewRecord(queueTicker.Symbol, order)
+			}
+			record.Price = order.Price
+			record.RemainingQuantity = order.Quantity
+			res = append(res, record)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreateTime > res[j].CreateTime
+	})
+
+	c.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"orders": res,
+		},
+	})
+}
+
+func orderHistory(c *gin.Context) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	page, err := orderStore.Query(OrderStore.Query{
+		Symbol:    c.Query("symbol"),
+		AccountId: requestAccount(c),
+		Status:    OrderStore.Status(c.Query("status")),
+		From:      from,
+		To:        to,
+		Limit:     limit,
+		Cursor:    c.Query("cursor"),
+	})
+	if err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": page,
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/User/internal/pkg/Journal"
+	"github.com/User/internal/pkg/OrderStore"
+	"github.com/User/internal/pkg/Snapshot"
+)
+
+// snapshotsKept is how many snapshot files are kept per symbol.
+const snapshotsKept = 3
+
+// snapshotDir is the -snapshot_dir flag; snapshots are disabled when empty.
+var snapshotDir string
+
+// restore rebuilds queueTicker, with its accounts and ledger, from the latest
+// valid snapshot and the journal commands after it, then starts journaling
+// new commands. Order history is rebuilt from the restored book.
+func restore(journalPath, journalSync string) error {
+	var after uint64
+	if snapshotDir != "" {
+		if s, path, ok := Snapshot.Latest(snapshotDir, queueTicker.Symbol); ok {
+			if err := queueTicker.Restore(s); err != nil {
+				return err
+			}
+			after = s.JournalSeq
+			log.Printf("restored snapshot %s at journal seq %d", path, after)
+		}
+	}
+
+	if journalPath != "" {
+		if err := replay(journalPath, journalSync, after); err != nil {
+			return err
+		}
+	}
+
+	// order history is not persisted, but the restored book is still queryable
+	for _, order := range queueTicker.Orders() {
+		orderStore.Add(OrderStore.NewRecord(queueTicker.Symbol, order))
+	}
+	return nil
+}
+
+func replay(journalPath, journalSync string, after uint64) error {
+	syncPolicy, err := Journal.ParseSyncPolicy(journalSync)
+	if err != nil {
+		return err
+	}
+	journal, err := Journal.Open(journalPath, Journal.Options{Sync: syncPolicy})
+	if err != nil {
+		return err
+	}
+	if journal.Seq() < after {
+		// the commands in between are lost, and new ones would reuse their
+		// sequence numbers
+		journal.Close()
+		return fmt.Errorf("journal %s ends at seq %d, before snapshot seq %d", journalPath, journal.Seq(), after)
+	}
+	if err := queueTicker.Replay(journal, after); err != nil {
+		return err
+	}
+	queueTicker.SetJournal(journal)
+	log.Printf("replayed journal up to seq %d, ask_len %d bid_len %d", queueTicker.JournalSeq(), queueTicker.AskLen(), queueTicker.BidLen())
+	return nil
+}
+
+// writeSnapshot writes a snapshot once the journal up to it is on disk, so a
+// snapshot is never ahead of the journal it continues.
+func writeSnapshot() (string, error) {
+	s := queueTicker.Snapshot()
+	if err := queueTicker.SyncJournal(); err != nil {
+		return "", err
+	}
+	path, err := Snapshot.Write(snapshotDir, s)
+	if err != nil {
+		return "", err
+	}
+	return path, Snapshot.Prune(snapshotDir, queueTicker.Symbol, snapshotsKept)
+}
+
+// snapshotTicker writes a snapshot every interval if any command was applied
+// since the previous one.
+func snapshotTicker(interval time.Duration) {
+	var lastSeq uint64
+	if s, _, ok := Snapshot.Latest(snapshotDir, queueTicker.Symbol); ok {
+		lastSeq = s.JournalSeq
+	}
+
+	ticker := time.NewTicker(interval)
+	for {
+		<-ticker.C
+		if seq := queueTicker.JournalSeq(); seq != lastSeq {
+			if _, err := writeSnapshot(); err != nil {
+				log.Printf("snapshot: %v", err)
+				continue
+			}
+			lastSeq = seq
+		}
+	}
+}
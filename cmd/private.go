@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/User/internal/pkg/Auth"
+	"github.com/User/internal/pkg/Queue"
+	"github.com/User/internal/pkg/wss"
+	"github.com/gin-gonic/gin"
+)
+
+var apiKeys *Auth.Keys
+
+// Private WS channels. A logged in client subscribes to them by name and
+// only receives its own account's messages.
+const (
+	channelOrders   = "orders"
+	channelFills    = "fills"
+	channelBalances = "balances"
+)
+
+// wsLogin authenticates a WS login by token or by signature.
+func wsLogin(login wss.Login) (string, error) {
+	if login.Token != "" {
+		return apiKeys.Token(login.Token)
+	}
+	if err := apiKeys.Verify(login.AccountId, login.Timestamp, login.Signature, time.Now()); err != nil {
+		return "", err
+	}
+	return login.AccountId, nil
+}
+
+// accountAuth authenticates a REST request with the credentials of the WS
+// login, sent as headers: X-Token, or X-Account-Id, X-Timestamp and
+// X-Signature. The handlers behind it act for requestAccount(c).
+func accountAuth(c *gin.Context) {
+	login := wss.Login{
+		Token:     c.GetHeader("X-Token"),
+		AccountId: c.GetHeader("X-Account-Id"),
+		Signature: c.GetHeader("X-Signature"),
+	}
+	login.Timestamp, _ = strconv.ParseInt(c.GetHeader("X-Timestamp"), 10, 64)
+	accountId, err := wsLogin(login)
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{
+			"ok":    false,
+			"error": "unauthorized",
+		})
+		return
+	}
+	c.Set(accountKey, accountId)
+	c.Next()
+}
+
+const accountKey = "account_id"
+
+// requestAccount is the account a request was authenticated for.
+func requestAccount(c *gin.Context) string {
+	return c.GetString(accountKey)
+}
+
+func sendPrivate(channel, accountId, tag string, data interface{}) {
+	sendMessage(wss.PrivateTopic(channel, accountId), tag, data)
+}
+
+// pushOrder sends the current state of an order to its owner.
+func pushOrder(orderId string) {
+	if r, ok := orderStore.Get(orderId); ok {
+		sendPrivate(channelOrders, r.AccountId, "order", r)
+	}
+}
+
+func pushBalances(accountId string) {
+	sendPrivate(channelBalances, accountId, "balances", gin.H{
+		"account_id": accountId,
+		"balances":   accounts.Balances(accountId),
+	})
+}
+
+// pushFills sends each side of a trade to its owner, with the fee charged on
+// what that side received.
+func pushFills(trade Queue.TradeResult) {
+	sendPrivate(channelFills, trade.AskAccountId, "fill", gin.H{
+		"trade_id":  trade.TradeId,
+		"order_id":  trade.AskOrderId,
+		"side":      "ask",
+		"price":     trade.TradePrice,
+		"quantity":  trade.TradeQuantity,
+		"amount":    trade.TradeAmount,
+		"fee":       trade.SellerFee,
+		"fee_asset": quoteAsset,
+		"time":      trade.TradeTime,
+	})
+	sendPrivate(channelFills, trade.BidAccountId, "fill", gin.H{
+		"trade_id":  trade.TradeId,
+		"order_id":  trade.BidOrderId,
+		"side":      "bid",
+		"price":     trade.TradePrice,
+		"quantity":  trade.TradeQuantity,
+		"amount":    trade.TradeAmount,
+		"fee":       trade.BuyerFee,
+		"fee_asset": baseAsset,
+		"time":      trade.TradeTime,
+	})
+}
@@ -0,0 +1,86 @@
+// Command snapshot inspects order book snapshot files written by the example
+// server.
+//
+//	snapshot inspect [-orders] <file>
+//	snapshot latest [-orders] <dir> <symbol>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/User/internal/pkg/Order"
+	"github.com/User/internal/pkg/Snapshot"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	orders := fs.Bool("orders", false, "print every resting order")
+	fs.Parse(os.Args[2:])
+
+	var (
+		s    Snapshot.Snapshot
+		path string
+		err  error
+	)
+	switch os.Args[1] {
+	case "inspect":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		path = fs.Arg(0)
+		s, err = Snapshot.Read(path)
+	case "latest":
+		if fs.NArg() != 2 {
+			usage()
+		}
+		var ok bool
+		if s, path, ok = Snapshot.Latest(fs.Arg(0), fs.Arg(1)); !ok {
+			err = fmt.Errorf("no valid snapshot of %s in %s", fs.Arg(1), fs.Arg(0))
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("file:         %s\n", path)
+	fmt.Printf("version:      %d (checksum ok)\n", Snapshot.Version)
+	fmt.Printf("symbol:       %s\n", s.Symbol)
+	fmt.Printf("created:      %s\n", time.Unix(0, s.CreateTime).Format(time.RFC3339Nano))
+	fmt.Printf("journal seq:  %d\n", s.JournalSeq)
+	fmt.Printf("trade seq:    %d\n", s.TradeSeq)
+	fmt.Printf("latest price: %s\n", s.LatestPrice)
+	fmt.Printf("status:       %s\n", s.Status)
+	fmt.Printf("asks:         %d orders\n", len(s.Asks))
+	fmt.Printf("bids:         %d orders\n", len(s.Bids))
+	fmt.Printf("accounts:     %d, %d holds, fee rate %s\n", len(s.Accounts.Balances), len(s.Accounts.Holds), s.Accounts.FeeRate)
+	fmt.Printf("postings:     %d\n", len(s.Postings))
+
+	if *orders {
+		printOrders("asks", s.Asks)
+		printOrders("bids", s.Bids)
+	}
+}
+
+func printOrders(title string, orders []Order.Order) {
+	fmt.Printf("\n%s:\n", title)
+	for _, o := range orders {
+		fmt.Printf("  %-40s account=%s price=%s quantity=%s create_time=%d expire_time=%d\n",
+			o.OrderId, o.AccountId, o.Price, o.Quantity, o.CreateTime, o.ExpireTime)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: snapshot inspect [-orders] <file>")
+	fmt.Fprintln(os.Stderr, "       snapshot latest [-orders] <dir> <symbol>")
+	os.Exit(2)
+}
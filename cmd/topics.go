@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/User/internal/pkg/Kline"
+	"github.com/User/internal/pkg/wss"
+	"github.com/User/pkg/Binary"
+	"github.com/gin-gonic/gin"
+)
+
+// WS topics are channel:symbol, plus a parameter for some channels:
+// depth:AA:<step> for grouped depth and kline:AA:<interval>.
+const (
+	topicDepth       = "depth"
+	topicDepthUpdate = "depth_update"
+	topicTrade       = "trade"
+	topicKline       = "kline"
+	topicTicker      = "ticker"
+	topicL3          = "l3"
+	topicBBO         = "bbo"
+)
+
+func topic(channel string, params ...string) string {
+	return strings.Join(append([]string{channel, queueTicker.Symbol}, params...), ":")
+}
+
+// validTopic reports whether a WS client may subscribe to a topic.
+func validTopic(t string) bool {
+	parts := strings.Split(t, ":")
+	if len(parts) < 2 || parts[1] != queueTicker.Symbol {
+		return false
+	}
+	switch parts[0] {
+	case topicDepth:
+		if len(parts) == 2 {
+			return true
+		}
+		if len(parts) != 3 {
+			return false
+		}
+		for _, step := range queueTicker.DepthSteps() {
+			if step.String() == parts[2] {
+				return true
+			}
+		}
+		return false
+	case topicKline:
+		if len(parts) != 3 {
+			return false
+		}
+		_, ok := Kline.ParseInterval(parts[2])
+		return ok
+	case topicDepthUpdate, topicTrade, topicTicker, topicL3, topicBBO:
+		return len(parts) == 2
+	}
+	return false
+}
+
+// tradeSnapshot is how many trades a client subscribing to the trade topic
+// gets first.
+const tradeSnapshot = 50
+
+// setTopicPolicies tells the hub what to do with each channel when a client
+// falls behind, what a client subscribing gets first, and which channels
+// have a compact binary layout, see package Binary.
+//
+// Snapshot-like channels only need their latest message, the public trade
+// feed may lose messages, and depth_update, l3 and the private channels are
+// never dropped since a client cannot do without any one of them. The latest message of the snapshot-like channels is also their
+// snapshot; depth_update and l3 are synced with the REST snapshots.
+func setTopicPolicies(hub *wss.Hub) {
+	hub.SetClass(wss.Conflated, topicDepth, topicKline, topicTicker, topicBBO)
+	hub.SetClass(wss.Droppable, topicTrade)
+	hub.SetSnapshot(1, topicDepth, topicKline, topicTicker, topicBBO)
+	hub.SetSnapshot(tradeSnapshot, topicTrade)
+	hub.SetBinary(topicDepth, Binary.EncodeDepth)
+	hub.SetBinary(topicTrade, Binary.EncodeTrade)
+}
+
+// wsClients lists the connected WS clients and their queue metrics.
+func wsClients(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": wss.HHub.Stats(),
+	})
+}
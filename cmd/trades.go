@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/User/internal/pkg/TradeStore"
+	"github.com/gin-gonic/gin"
+)
+
+// trades is the public trade history. It does not say whose trades they are.
+func trades(c *gin.Context) {
+	page, ok := queryTrades(c, "")
+	if !ok {
+		return
+	}
+	for i := range page.Trades {
+		page.Trades[i].AskAccountId = ""
+		page.Trades[i].BidAccountId = ""
+	}
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": page,
+	})
+}
+
+// myTrades is the trade history of the account a request was authenticated
+// for.
+func myTrades(c *gin.Context) {
+	page, ok := queryTrades(c, requestAccount(c))
+	if !ok {
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"ok":   true,
+		"data": page,
+	})
+}
+
+func queryTrades(c *gin.Context, accountId string) (TradeStore.Page, bool) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	page, err := tradeStore.Query(TradeStore.Query{
+		Symbol:    c.Query("symbol"),
+		From:      from,
+		To:        to,
+		OrderId:   c.Query("order_id"),
+		AccountId: accountId,
+		Limit:     limit,
+		Cursor:    c.Query("cursor"),
+	})
+	if err != nil {
+		c.JSON(200, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return page, false
+	}
+	return page, true
+}
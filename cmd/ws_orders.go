@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/User/internal/pkg/Risk"
+	"github.com/User/internal/pkg/wss"
+	"github.com/gin-gonic/gin"
+)
+
+// WS order entry ops. They take the same fields as the REST API next to op
+// and id, and act for the logged in account only.
+const (
+	opPlaceOrder  = "place_order"
+	opCancelOrder = "cancel_order"
+	opAmendOrder  = "amend_order"
+	opCancelAll   = "cancel_all"
+)
+
+func handleWsOrders(hub *wss.Hub) {
+	hub.Handle(opPlaceOrder, wsPlaceOrder)
+	hub.Handle(opCancelOrder, wsCancelOrder)
+	hub.Handle(opAmendOrder, wsAmendOrder)
+	hub.Handle(opCancelAll, wsCancelAll)
+	hub.Handle(opCancelOnDisconnect, wsCancelOnDisconnect)
+	hub.OnDisconnect(sessions.disconnected)
+}
+
+func wsError(rejection *Risk.Rejection) *wss.Error {
+	return &wss.Error{Code: string(rejection.Code), Message: rejection.Message}
+}
+
+func wsBadRequest(err error) *wss.Error {
+	return &wss.Error{Code: wss.ErrBadRequest, Message: err.Error()}
+}
+
+func wsPlaceOrder(session wss.Session, request []byte) (interface{}, *wss.Error) {
+	var param orderRequest
+	if err := json.Unmarshal(request, &param); err != nil {
+		return nil, wsBadRequest(err)
+	}
+	param.AccountId = session.AccountId
+
+	if rejection := placeOrder(&param); rejection != nil {
+		return nil, wsError(rejection)
+	}
+	sessions.placed(session, param.OrderId)
+	return gin.H{"order_id": param.OrderId}, nil
+}
+
+func wsCancelOrder(session wss.Session, request []byte) (interface{}, *wss.Error) {
+	var param struct {
+		OrderId string `json:"order_id"`
+	}
+	if err := json.Unmarshal(request, &param); err != nil {
+		return nil, wsBadRequest(err)
+	}
+
+	if rejection := cancelById(session.AccountId, param.OrderId); rejection != nil {
+		return nil, wsError(rejection)
+	}
+	return gin.H{"order_id": param.OrderId}, nil
+}
+
+func wsAmendOrder(session wss.Session, request []byte) (interface{}, *wss.Error) {
+	var param amendRequest
+	if err := json.Unmarshal(request, &param); err != nil {
+		return nil, wsBadRequest(err)
+	}
+	param.AccountId = session.AccountId
+
+	if rejection := placeAmend(&param); rejection != nil {
+		return nil, wsError(rejection)
+	}
+	return gin.H{"order_id": param.OrderId}, nil
+}
+
+func wsCancelAll(session wss.Session, request []byte) (interface{}, *wss.Error) {
+	return gin.H{"canceled": cancelAll(session.AccountId)}, nil
+}
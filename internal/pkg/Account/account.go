@@ -0,0 +1,442 @@
+package Account
+
+import (
+	"errors"
+	"sync"
+
+	. "github.com/User/internal/pkg/Order"
+	"github.com/shopspring/decimal"
+)
+
+// FeeAccount collects the trading fees of every settled trade.
+const FeeAccount = "fee"
+
+// feePrecision is the number of decimal places fees are truncated to.
+const feePrecision = 8
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInvalidAmount     = errors.New("amount must be greater than 0")
+	ErrDuplicateHold     = errors.New("order already holds funds")
+	ErrUnknownHold       = errors.New("order holds no funds")
+)
+
+// Balance is the state of one asset of one account. Held funds are reserved
+// by open orders and cannot be used to place new ones.
+type Balance struct {
+	Available decimal.Decimal `json:"available"`
+	Held      decimal.Decimal `json:"held"`
+}
+
+// OrderHold is the reservation made for one open order. Amount is what is
+// still held in Asset, Quantity is the order quantity that is still unfilled.
+type OrderHold struct {
+	AccountId string          `json:"account_id"`
+	Base      string          `json:"base"`
+	Quote     string          `json:"quote"`
+	Asset     string          `json:"asset"`
+	Amount    decimal.Decimal `json:"amount"`
+	Quantity  decimal.Decimal `json:"quantity"`
+}
+
+// Settlement describes how the funds of one trade moved. The buyer receives
+// Quantity of Base minus BuyerFee, the seller receives Amount of Quote minus
+// SellerFee and both fees go to FeeAccount.
+type Settlement struct {
+	Seller    string
+	Buyer     string
+	Base      string
+	Quote     string
+	Quantity  decimal.Decimal
+	Amount    decimal.Decimal
+	BuyerFee  decimal.Decimal
+	SellerFee decimal.Decimal
+}
+
+type Accounts struct {
+	balances  map[string]map[string]*Balance
+	holds     map[string]*OrderHold
+	deposited map[string]map[string]decimal.Decimal
+	feeRate   decimal.Decimal
+
+	sync.Mutex
+}
+
+func NewAccounts() *Accounts {
+	return &Accounts{
+		balances:  make(map[string]map[string]*Balance),
+		holds:     make(map[string]*OrderHold),
+		deposited: make(map[string]map[string]decimal.Decimal),
+	}
+}
+
+// State is everything Accounts keeps, as saved in a snapshot.
+type State struct {
+	Balances  map[string]map[string]Balance         `json:"balances"`
+	Holds     map[string]OrderHold                  `json:"holds"`
+	Deposited map[string]map[string]decimal.Decimal `json:"deposited"`
+	FeeRate   decimal.Decimal                       `json:"fee_rate"`
+}
+
+// State returns a copy of every balance, hold and deposit.
+func (a *Accounts) State() State {
+	a.Lock()
+	defer a.Unlock()
+
+	s := State{
+		Balances:  make(map[string]map[string]Balance),
+		Holds:     make(map[string]OrderHold),
+		Deposited: make(map[string]map[string]decimal.Decimal),
+		FeeRate:   a.feeRate,
+	}
+	for accountId, assets := range a.balances {
+		s.Balances[accountId] = make(map[string]Balance)
+		for asset, b := range assets {
+			s.Balances[accountId][asset] = *b
+		}
+	}
+	for orderId, h := range a.holds {
+		s.Holds[orderId] = *h
+	}
+	for accountId, assets := range a.deposited {
+		s.Deposited[accountId] = make(map[string]decimal.Decimal)
+		for asset, amount := range assets {
+			s.Deposited[accountId][asset] = amount
+		}
+	}
+	return s
+}
+
+// Restore replaces every balance, hold and deposit with a saved State.
+func (a *Accounts) Restore(s State) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.balances = make(map[string]map[string]*Balance)
+	a.holds = make(map[string]*OrderHold)
+	a.deposited = make(map[string]map[string]decimal.Decimal)
+	a.feeRate = s.FeeRate
+	for accountId, assets := range s.Balances {
+		for asset, b := range assets {
+			*a.balance(accountId, asset) = b
+		}
+	}
+	for orderId, h := range s.Holds {
+		h := h
+		a.holds[orderId] = &h
+	}
+	for accountId, assets := range s.Deposited {
+		a.deposited[accountId] = make(map[string]decimal.Decimal)
+		for asset, amount := range assets {
+			a.deposited[accountId][asset] = amount
+		}
+	}
+}
+
+// FeeRate returns the rate set by SetFeeRate.
+func (a *Accounts) FeeRate() decimal.Decimal {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.feeRate
+}
+
+// SetFeeRate sets the fee charged on what each side of a trade receives,
+// e.g. 0.001 for 0.1%.
+func (a *Accounts) SetFeeRate(rate decimal.Decimal) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.feeRate = rate
+}
+
+func (a *Accounts) balance(accountId, asset string) *Balance {
+	assets, ok := a.balances[accountId]
+	if !ok {
+		assets = make(map[string]*Balance)
+		a.balances[accountId] = assets
+	}
+	b, ok := assets[asset]
+	if !ok {
+		b = &Balance{}
+		assets[asset] = b
+	}
+	return b
+}
+
+func (a *Accounts) Deposit(accountId, asset string, amount decimal.Decimal) error {
+	if !amount.IsPositive() {
+		return ErrInvalidAmount
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	b := a.balance(accountId, asset)
+	b.Available = b.Available.Add(amount)
+	if _, ok := a.deposited[accountId]; !ok {
+		a.deposited[accountId] = make(map[string]decimal.Decimal)
+	}
+	a.deposited[accountId][asset] = a.deposited[accountId][asset].Add(amount)
+	return nil
+}
+
+// Totals returns, per asset, the sum of the available and held balances of
+// every account and the sum of every deposit. Settlement only moves funds
+// between accounts, so the two must always be equal.
+func (a *Accounts) Totals() (total, deposited map[string]decimal.Decimal) {
+	a.Lock()
+	defer a.Unlock()
+
+	total = make(map[string]decimal.Decimal)
+	deposited = make(map[string]decimal.Decimal)
+	for _, assets := range a.balances {
+		for asset, b := range assets {
+			total[asset] = total[asset].Add(b.Available).Add(b.Held)
+		}
+	}
+	for _, assets := range a.deposited {
+		for asset, amount := range assets {
+			deposited[asset] = deposited[asset].Add(amount)
+		}
+	}
+	return total, deposited
+}
+
+// Position is what an account has of an asset, available and held, and what
+// it deposited of it.
+type Position struct {
+	Total     decimal.Decimal
+	Deposited decimal.Decimal
+}
+
+// Positions returns the position of every account in every asset it holds or
+// deposited. Total minus Deposited is what trading moved in or out.
+func (a *Accounts) Positions() map[string]map[string]Position {
+	a.Lock()
+	defer a.Unlock()
+
+	res := make(map[string]map[string]Position)
+	position := func(accountId, asset string) Position {
+		if _, ok := res[accountId]; !ok {
+			res[accountId] = make(map[string]Position)
+		}
+		return res[accountId][asset]
+	}
+	for accountId, assets := range a.balances {
+		for asset, b := range assets {
+			p := position(accountId, asset)
+			p.Total = b.Available.Add(b.Held)
+			res[accountId][asset] = p
+		}
+	}
+	for accountId, assets := range a.deposited {
+		for asset, amount := range assets {
+			p := position(accountId, asset)
+			p.Deposited = amount
+			res[accountId][asset] = p
+		}
+	}
+	return res
+}
+
+// Balances returns a copy of every asset balance of an account.
+func (a *Accounts) Balances(accountId string) map[string]Balance {
+	a.Lock()
+	defer a.Unlock()
+
+	res := make(map[string]Balance)
+	for asset, b := range a.balances[accountId] {
+		res[asset] = *b
+	}
+	return res
+}
+
+// Available returns the available balance of one asset of an account.
+func (a *Accounts) Available(accountId, asset string) decimal.Decimal {
+	a.Lock()
+	defer a.Unlock()
+
+	if b, ok := a.balances[accountId][asset]; ok {
+		return b.Available
+	}
+	return decimal.Zero
+}
+
+// OpenOrders returns how many orders of an account on the base/quote pair
+// currently hold funds.
+func (a *Accounts) OpenOrders(accountId, base, quote string) int {
+	a.Lock()
+	defer a.Unlock()
+
+	n := 0
+	for _, h := range a.holds {
+		if h.AccountId == accountId && h.Base == base && h.Quote == quote {
+			n++
+		}
+	}
+	return n
+}
+
+// OpenBids returns the unfilled quantity of every bid an account has open on
+// the base/quote pair, by order id. It is the base those bids stand to buy.
+func (a *Accounts) OpenBids(accountId, base, quote string) map[string]decimal.Decimal {
+	a.Lock()
+	defer a.Unlock()
+
+	res := make(map[string]decimal.Decimal)
+	for orderId, h := range a.holds {
+		if h.AccountId == accountId && h.Base == base && h.Quote == quote && h.Asset == quote {
+			res[orderId] = h.Quantity
+		}
+	}
+	return res
+}
+
+// Hold reserves the funds an order needs before it is added to the book:
+// quote for bids (price * quantity, or Amount for market bids) and base for
+// asks.
+func (a *Accounts) Hold(order Order, base, quote string) error {
+	h := &OrderHold{
+		AccountId: order.AccountId,
+		Base:      base,
+		Quote:     quote,
+		Quantity:  order.Quantity,
+	}
+	if order.OrderType == OrderSell {
+		h.Asset = base
+		h.Amount = order.Quantity
+	} else if order.PriceType == PriceMarket {
+		h.Asset = quote
+		h.Amount = order.Amount
+	} else {
+		h.Asset = quote
+		h.Amount = order.Price.Mul(order.Quantity)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.holds[order.OrderId]; ok {
+		return ErrDuplicateHold
+	}
+	b := a.balance(h.AccountId, h.Asset)
+	if !h.Amount.IsPositive() || b.Available.Cmp(h.Amount) < 0 {
+		return ErrInsufficientFunds
+	}
+	b.Available = b.Available.Sub(h.Amount)
+	b.Held = b.Held.Add(h.Amount)
+	a.holds[order.OrderId] = h
+	return nil
+}
+
+// Amend resizes the hold of an open order to a new price and remaining
+// quantity, taking more funds or releasing the difference. Only the account
+// that placed the order may amend it.
+func (a *Accounts) Amend(orderId, accountId string, price, quantity decimal.Decimal) error {
+	a.Lock()
+	defer a.Unlock()
+
+	h, ok := a.holds[orderId]
+	if !ok || h.AccountId != accountId {
+		return ErrUnknownHold
+	}
+	amount := quantity
+	if h.Asset == h.Quote {
+		amount = price.Mul(quantity)
+	}
+	return a.resize(h, amount, quantity)
+}
+
+func (a *Accounts) resize(h *OrderHold, amount, quantity decimal.Decimal) error {
+	b := a.balance(h.AccountId, h.Asset)
+	diff := amount.Sub(h.Amount)
+	if b.Available.Cmp(diff) < 0 {
+		return ErrInsufficientFunds
+	}
+	b.Available = b.Available.Sub(diff)
+	b.Held = b.Held.Add(diff)
+	h.Amount = amount
+	h.Quantity = quantity
+	return nil
+}
+
+// Release returns whatever an order still holds to the available balance.
+// It is called when an order is cancelled or expires.
+func (a *Accounts) Release(orderId string) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.release(orderId)
+}
+
+func (a *Accounts) release(orderId string) {
+	h, ok := a.holds[orderId]
+	if !ok {
+		return
+	}
+	b := a.balance(h.AccountId, h.Asset)
+	b.Held = b.Held.Sub(h.Amount)
+	b.Available = b.Available.Add(h.Amount)
+	delete(a.holds, orderId)
+}
+
+// Settle moves the funds of one trade between the seller and the buyer: the
+// seller's held base goes to the buyer and the buyer's held quote goes to the
+// seller, each minus the fee that goes to FeeAccount. Both sides are applied
+// under one lock, so a trade is never half settled. A hold whose order is
+// completely filled is released.
+func (a *Accounts) Settle(askOrderId, bidOrderId string, quantity, amount decimal.Decimal) (Settlement, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	ask, ok := a.holds[askOrderId]
+	if !ok {
+		return Settlement{}, ErrUnknownHold
+	}
+	bid, ok := a.holds[bidOrderId]
+	if !ok {
+		return Settlement{}, ErrUnknownHold
+	}
+	if ask.Amount.Cmp(quantity) < 0 || bid.Amount.Cmp(amount) < 0 {
+		return Settlement{}, ErrInsufficientFunds
+	}
+
+	s := Settlement{
+		Seller:    ask.AccountId,
+		Buyer:     bid.AccountId,
+		Base:      ask.Base,
+		Quote:     ask.Quote,
+		Quantity:  quantity,
+		Amount:    amount,
+		BuyerFee:  quantity.Mul(a.feeRate).Truncate(feePrecision),
+		SellerFee: amount.Mul(a.feeRate).Truncate(feePrecision),
+	}
+
+	seller := a.balance(s.Seller, s.Base)
+	seller.Held = seller.Held.Sub(quantity)
+	ask.Amount = ask.Amount.Sub(quantity)
+	ask.Quantity = ask.Quantity.Sub(quantity)
+	proceeds := a.balance(s.Seller, s.Quote)
+	proceeds.Available = proceeds.Available.Add(amount.Sub(s.SellerFee))
+
+	buyer := a.balance(s.Buyer, s.Quote)
+	buyer.Held = buyer.Held.Sub(amount)
+	bid.Amount = bid.Amount.Sub(amount)
+	bid.Quantity = bid.Quantity.Sub(quantity)
+	bought := a.balance(s.Buyer, s.Base)
+	bought.Available = bought.Available.Add(quantity.Sub(s.BuyerFee))
+
+	baseFee := a.balance(FeeAccount, s.Base)
+	baseFee.Available = baseFee.Available.Add(s.BuyerFee)
+	quoteFee := a.balance(FeeAccount, s.Quote)
+	quoteFee.Available = quoteFee.Available.Add(s.SellerFee)
+
+	if !ask.Quantity.IsPositive() {
+		a.release(askOrderId)
+	}
+	if !bid.Quantity.IsPositive() {
+		a.release(bidOrderId)
+	}
+	return s, nil
+}
@@ -0,0 +1,125 @@
+package Auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Window is how far the timestamp of a signed login may be from the server
+// clock, either way.
+const Window = 30 * time.Second
+
+var (
+	ErrUnauthorized = errors.New("invalid credentials")
+	ErrExpired      = errors.New("login timestamp outside the allowed window")
+	ErrEmpty        = errors.New("credentials need an account and a token or a secret")
+)
+
+// Credentials let a client act for one account, either by presenting Token
+// or by signing a login with Secret. An empty Token or Secret cannot be used
+// to log in.
+type Credentials struct {
+	AccountId string `json:"account_id"`
+	Token     string `json:"token"`
+	Secret    string `json:"secret"`
+}
+
+// Sign returns the signature of a login: the hex HMAC-SHA256, keyed by the
+// secret, of "<account id>:<timestamp in unix milliseconds>".
+func Sign(secret, accountId string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%d", accountId, timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Keys holds the credentials of every account. byAccount only has the
+// credentials that carry a secret to sign with.
+type Keys struct {
+	byToken   map[string]Credentials
+	byAccount map[string][]Credentials
+
+	sync.RWMutex
+}
+
+func NewKeys() *Keys {
+	return &Keys{
+		byToken:   make(map[string]Credentials),
+		byAccount: make(map[string][]Credentials),
+	}
+}
+
+func (k *Keys) Add(c Credentials) error {
+	if c.AccountId == "" || (c.Token == "" && c.Secret == "") {
+		return ErrEmpty
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	if c.Token != "" {
+		k.byToken[c.Token] = c
+	}
+	if c.Secret != "" {
+		k.byAccount[c.AccountId] = append(k.byAccount[c.AccountId], c)
+	}
+	return nil
+}
+
+// Generate creates and adds random credentials for an account.
+func (k *Keys) Generate(accountId string) (Credentials, error) {
+	token, err := random()
+	if err != nil {
+		return Credentials{}, err
+	}
+	secret, err := random()
+	if err != nil {
+		return Credentials{}, err
+	}
+	c := Credentials{AccountId: accountId, Token: token, Secret: secret}
+	return c, k.Add(c)
+}
+
+func random() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Token returns the account a token belongs to.
+func (k *Keys) Token(token string) (string, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	c, ok := k.byToken[token]
+	if !ok || token == "" {
+		return "", ErrUnauthorized
+	}
+	return c.AccountId, nil
+}
+
+// Verify checks a signed login of accountId made at timestamp (unix
+// milliseconds) against every secret of the account.
+func (k *Keys) Verify(accountId string, timestamp int64, signature string, now time.Time) error {
+	skew := now.Sub(time.UnixMilli(timestamp))
+	if skew > Window || skew < -Window {
+		return ErrExpired
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	for _, c := range k.byAccount[accountId] {
+		if hmac.Equal([]byte(Sign(c.Secret, accountId, timestamp)), []byte(signature)) {
+			return nil
+		}
+	}
+	return ErrUnauthorized
+}
@@ -0,0 +1,250 @@
+package Depth
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/User/internal/pkg/Queue"
+	"github.com/User/pkg/Checksum"
+	"github.com/shopspring/decimal"
+)
+
+// Update is one incremental depth message. Bids and Asks hold only the
+// levels that changed since the previous update, as [price, quantity]; a
+// quantity of "0" removes the level. Every changed level takes one update
+// id, so an update covers FirstUpdateId to LastUpdateId and the next update
+// starts at LastUpdateId + 1. Checksum is that of the book once the update
+// is applied, see package Checksum.
+type Update struct {
+	Symbol        string      `json:"symbol"`
+	FirstUpdateId uint64      `json:"first_update_id"`
+	LastUpdateId  uint64      `json:"last_update_id"`
+	Bids          [][2]string `json:"bids"`
+	Asks          [][2]string `json:"asks"`
+	Checksum      uint32      `json:"checksum"`
+}
+
+// Snapshot is the full book as of LastUpdateId. A client applies the updates
+// whose LastUpdateId is above it, starting with the one that covers
+// LastUpdateId + 1.
+type Snapshot struct {
+	Symbol       string      `json:"symbol"`
+	LastUpdateId uint64      `json:"last_update_id"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+	Checksum     uint32      `json:"checksum"`
+}
+
+// Book is the published state of one order book. It follows the engine's L3
+// book events, keeping the orders and the price levels they make up, and
+// collects the levels that changed until Flush turns them into an update. So
+// the cost of an update is that of the changes, not of the whole book.
+type Book struct {
+	symbol   string
+	updateId uint64
+	seq      uint64
+	orders   map[string]resting
+	bids     *side
+	asks     *side
+
+	sync.Mutex
+}
+
+// resting is what the book needs to know of one resting order.
+type resting struct {
+	bid      bool
+	price    decimal.Decimal
+	quantity decimal.Decimal
+}
+
+func NewBook(symbol string) *Book {
+	return &Book{
+		symbol: symbol,
+		orders: make(map[string]resting),
+		bids:   newSide(true),
+		asks:   newSide(false),
+	}
+}
+
+// Load replaces the book with an L3 snapshot, as the starting point of the
+// events that follow it. Levels it changes are published by the next Flush.
+func (b *Book) Load(s Queue.BookSnapshot) {
+	b.Lock()
+	defer b.Unlock()
+
+	for id, o := range b.orders {
+		b.remove(id, o)
+	}
+	for _, o := range append(s.Bids, s.Asks...) {
+		b.insert(o.OrderId, resting{bid: o.Side == "bid", price: o.Price, quantity: o.Quantity})
+	}
+	b.seq = s.Seq
+}
+
+// Apply applies one L3 book event. Events already covered by the loaded
+// snapshot are ignored. An execute is followed by the modify or delete it
+// causes, which is what changes the level.
+func (b *Book) Apply(e Queue.BookEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	if e.Seq <= b.seq {
+		return
+	}
+	b.seq = e.Seq
+
+	switch e.Type {
+	case Queue.BookAdd, Queue.BookModify:
+		if o, ok := b.orders[e.OrderId]; ok {
+			b.remove(e.OrderId, o)
+		}
+		b.insert(e.OrderId, resting{bid: e.Side == "bid", price: e.Price, quantity: e.Quantity})
+	case Queue.BookDelete:
+		if o, ok := b.orders[e.OrderId]; ok {
+			b.remove(e.OrderId, o)
+		}
+	}
+}
+
+func (b *Book) insert(orderId string, o resting) {
+	b.orders[orderId] = o
+	b.side(o.bid).add(o.price, o.quantity)
+}
+
+func (b *Book) remove(orderId string, o resting) {
+	delete(b.orders, orderId)
+	b.side(o.bid).add(o.price, o.quantity.Neg())
+}
+
+func (b *Book) side(bid bool) *side {
+	if bid {
+		return b.bids
+	}
+	return b.asks
+}
+
+// Flush returns the levels changed since the previous flush as an update.
+// ok is false when nothing changed.
+func (b *Book) Flush() (u Update, ok bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	u = Update{
+		Symbol: b.symbol,
+		Bids:   b.bids.flush(),
+		Asks:   b.asks.flush(),
+	}
+	changes := uint64(len(u.Bids) + len(u.Asks))
+	if changes == 0 {
+		return u, false
+	}
+	u.FirstUpdateId = b.updateId + 1
+	b.updateId += changes
+	u.LastUpdateId = b.updateId
+	u.Checksum = Checksum.Compute(b.bids.top(Checksum.Levels), b.asks.top(Checksum.Levels))
+	return u, true
+}
+
+// Snapshot returns the top limit levels of each side, or every level when
+// limit is not positive, as of the last flush.
+func (b *Book) Snapshot(limit int) Snapshot {
+	b.Lock()
+	defer b.Unlock()
+
+	bids, asks := b.bids.top(limit), b.asks.top(limit)
+	return Snapshot{
+		Symbol:       b.symbol,
+		LastUpdateId: b.updateId,
+		Bids:         bids,
+		Asks:         asks,
+		Checksum:     Checksum.Compute(bids, asks),
+	}
+}
+
+// side is one side of the book: the current quantity of every level, the
+// levels changed since the last flush, and the levels as published by it,
+// best first. Levels are keyed by their price as shown in the messages.
+type side struct {
+	desc      bool
+	levels    map[string]decimal.Decimal
+	changed   map[string]bool
+	published [][2]string
+}
+
+func newSide(desc bool) *side {
+	return &side{
+		desc:      desc,
+		levels:    make(map[string]decimal.Decimal),
+		changed:   make(map[string]bool),
+		published: [][2]string{},
+	}
+}
+
+func (s *side) add(price, quantity decimal.Decimal) {
+	// levels group prices to 2 decimals, like the depth of the engine
+	key := Queue.FormatDecimal2String(price.Round(2), 2)
+	total := s.levels[key].Add(quantity)
+	if total.IsPositive() {
+		s.levels[key] = total
+	} else {
+		delete(s.levels, key)
+	}
+	s.changed[key] = true
+}
+
+// better reports whether price a comes before price b on this side.
+func (s *side) better(a, b string) bool {
+	pa, _ := decimal.NewFromString(a)
+	pb, _ := decimal.NewFromString(b)
+	if s.desc {
+		return pa.GreaterThan(pb)
+	}
+	return pa.LessThan(pb)
+}
+
+// flush publishes the changed levels and returns those whose quantity is not
+// the published one any more, best first, with "0" for removed levels.
+func (s *side) flush() [][2]string {
+	res := [][2]string{}
+	for key := range s.changed {
+		// a level too small to show is published as removed, as the
+		// engine depth leaves it out
+		quantity := "0"
+		if total, ok := s.levels[key]; ok {
+			if shown, ok := Queue.DepthQuantity(total); ok {
+				quantity = shown
+			}
+		}
+
+		i := sort.Search(len(s.published), func(i int) bool { return !s.better(s.published[i][0], key) })
+		found := i < len(s.published) && s.published[i][0] == key
+		switch {
+		case found && quantity == "0":
+			s.published = append(s.published[:i], s.published[i+1:]...)
+		case found && s.published[i][1] != quantity:
+			s.published[i][1] = quantity
+		case !found && quantity != "0":
+			s.published = append(s.published, [2]string{})
+			copy(s.published[i+1:], s.published[i:])
+			s.published[i] = [2]string{key, quantity}
+		default:
+			continue
+		}
+		res = append(res, [2]string{key, quantity})
+	}
+	s.changed = make(map[string]bool)
+
+	sort.Slice(res, func(i, j int) bool { return s.better(res[i][0], res[j][0]) })
+	return res
+}
+
+// top returns the best limit published levels, or all of them when limit is
+// not positive.
+func (s *side) top(limit int) [][2]string {
+	if limit <= 0 || limit > len(s.published) {
+		limit = len(s.published)
+	}
+	res := make([][2]string, limit)
+	copy(res, s.published)
+	return res
+}
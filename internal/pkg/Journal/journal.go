@@ -0,0 +1,325 @@
+package Journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/User/internal/pkg/Order"
+	"github.com/shopspring/decimal"
+)
+
+type CommandType string
+
+const (
+	CommandNew    CommandType = "new"
+	CommandCancel CommandType = "cancel"
+	CommandAmend  CommandType = "amend"
+	CommandExpire CommandType = "expire"
+	CommandStatus CommandType = "status"
+	// CommandDeposit credits Amount of Asset to AccountId.
+	CommandDeposit CommandType = "deposit"
+	// CommandFeeRate sets the trading fee rate to Amount.
+	CommandFeeRate CommandType = "fee_rate"
+)
+
+// Command is one accepted engine command. New carries the full order; cancel
+// and expire carry OrderId and OrderType; amend additionally carries the new
+// Price, Quantity and CreateTime. Status carries only the new trading status.
+// Deposit and fee rate carry no order.
+type Command struct {
+	Seq       uint64          `json:"seq"`
+	Type      CommandType     `json:"type"`
+	Order     Order           `json:"order"`
+	Status    string          `json:"status,omitempty"`
+	AccountId string          `json:"account_id,omitempty"`
+	Asset     string          `json:"asset,omitempty"`
+	Amount    decimal.Decimal `json:"amount"`
+}
+
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every appended command.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in the background every Options.SyncInterval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return SyncAlways, fmt.Errorf("unknown sync policy %q", s)
+}
+
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+// headerSize is the length prefix plus the CRC32 of every record.
+const headerSize = 8
+
+var (
+	ErrClosed  = errors.New("journal closed")
+	ErrCorrupt = errors.New("corrupt journal record")
+)
+
+// Journal is an append-only file of commands. Every record is a big-endian
+// uint32 payload length, the IEEE CRC32 of the payload and the JSON encoded
+// Command.
+type Journal struct {
+	file    *os.File
+	options Options
+	seq     uint64
+	size    int64
+	dirty   bool
+	closed  bool
+	done    chan struct{}
+
+	sync.Mutex
+}
+
+// Open opens or creates a journal file. A torn or corrupt record at the end of
+// the file, left by a crash in the middle of a write, is truncated away. An
+// invalid record with more records after it is not the result of a crash, so
+// Open fails with ErrCorrupt rather than drop the commands that follow.
+func Open(path string, options Options) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		file:    file,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	end, err := j.scan(0, func(cmd Command) error {
+		j.seq = cmd.Seq
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	j.size = end
+
+	if options.Sync == SyncInterval {
+		if options.SyncInterval <= 0 {
+			j.options.SyncInterval = 100 * time.Millisecond
+		}
+		go j.syncTicker()
+	}
+	return j, nil
+}
+
+// Seq returns the sequence number of the last appended command.
+func (j *Journal) Seq() uint64 {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.seq
+}
+
+// Append assigns the next sequence number to a command and writes it to the
+// journal. With SyncAlways the command is on disk when Append returns. A
+// command Append fails on is not left in the journal.
+func (j *Journal) Append(cmd Command) (uint64, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.closed {
+		return 0, ErrClosed
+	}
+
+	cmd.Seq = j.seq + 1
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, err
+	}
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[headerSize:], payload)
+
+	if _, err := j.file.Write(record); err != nil {
+		// drop a partially written record so later appends stay readable
+		j.rollback()
+		return 0, err
+	}
+	j.dirty = true
+
+	if j.options.Sync == SyncAlways {
+		if err := j.sync(); err != nil {
+			// the caller does not apply a command that failed, so it must
+			// not be replayed either
+			j.rollback()
+			return 0, err
+		}
+	}
+	j.size += int64(len(record))
+	j.seq = cmd.Seq
+	return cmd.Seq, nil
+}
+
+// rollback truncates the journal back to its last appended record.
+func (j *Journal) rollback() {
+	j.file.Truncate(j.size)
+	j.file.Seek(j.size, io.SeekStart)
+}
+
+// Replay calls fn, in order, with every command whose sequence number is
+// greater than after.
+func (j *Journal) Replay(after uint64, fn func(Command) error) error {
+	j.Lock()
+	defer j.Unlock()
+
+	_, err := j.scan(after, fn)
+	return err
+}
+
+// scan reads the journal from the start and returns the offset of the end of
+// the last valid record. Only the last record may be invalid, or the tail of
+// the file zero-filled, as a crash in the middle of a write leaves it.
+func (j *Journal) scan(after uint64, fn func(Command) error) (int64, error) {
+	info, err := j.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	defer j.file.Seek(0, io.SeekEnd)
+
+	reader := bufio.NewReader(j.file)
+	header := make([]byte, headerSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return offset, nil
+		}
+		end := offset + headerSize + int64(binary.BigEndian.Uint32(header[0:4]))
+		if end > info.Size() {
+			// the payload was not completely written
+			return offset, nil
+		}
+		payload := make([]byte, end-offset-headerSize)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, err
+		}
+		var cmd Command
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) || json.Unmarshal(payload, &cmd) != nil {
+			if end == info.Size() || (zero(header) && zero(payload) && zeroTail(reader)) {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w at offset %d", ErrCorrupt, offset)
+		}
+		offset = end
+
+		if cmd.Seq > after {
+			if err := fn(cmd); err != nil {
+				return offset, err
+			}
+		}
+	}
+}
+
+func zero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// zeroTail reports whether everything left in r is zero bytes.
+func zeroTail(r *bufio.Reader) bool {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if !zero(buf[:n]) {
+			return false
+		}
+		if err != nil {
+			return err == io.EOF
+		}
+	}
+}
+
+// Sync flushes every appended command to disk.
+func (j *Journal) Sync() error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.closed {
+		return ErrClosed
+	}
+	return j.sync()
+}
+
+func (j *Journal) sync() error {
+	if !j.dirty {
+		return nil
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+func (j *Journal) syncTicker() {
+	ticker := time.NewTicker(j.options.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.Lock()
+			if !j.closed {
+				j.sync()
+			}
+			j.Unlock()
+		case <-j.done:
+			return
+		}
+	}
+}
+
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	close(j.done)
+	if err := j.sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,217 @@
+package Kline
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type Interval struct {
+	Name     string
+	Duration time.Duration
+}
+
+var Intervals = []Interval{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+	{"1h", time.Hour},
+	{"4h", 4 * time.Hour},
+	{"1d", 24 * time.Hour},
+}
+
+func ParseInterval(name string) (Interval, bool) {
+	for _, interval := range Intervals {
+		if interval.Name == name {
+			return interval, true
+		}
+	}
+	return Interval{}, false
+}
+
+// seconds is the length of the interval in unix seconds.
+func (i Interval) seconds() int64 {
+	return int64(i.Duration / time.Second)
+}
+
+// openTime is the start of the interval that contains t (unix seconds).
+func (i Interval) openTime(t int64) int64 {
+	return t - t%i.seconds()
+}
+
+// Candle is the OHLCV of one symbol over one interval. OpenTime and
+// CloseTime are unix seconds; CloseTime is the last second of the interval.
+type Candle struct {
+	Symbol      string          `json:"symbol"`
+	Interval    string          `json:"interval"`
+	OpenTime    int64           `json:"open_time"`
+	CloseTime   int64           `json:"close_time"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Close       decimal.Decimal `json:"close"`
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quote_volume"`
+	Trades      int             `json:"trades"`
+}
+
+const (
+	// maxCandles is how many candles are kept per symbol and interval.
+	maxCandles = 5000
+
+	DefaultLimit = 500
+	MaxLimit     = 1500
+)
+
+// Aggregator builds candles of every interval from trades. Only intervals
+// with trades are stored; empty ones are filled in when queried.
+type Aggregator struct {
+	series map[string]map[string][]Candle
+
+	sync.RWMutex
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		series: make(map[string]map[string][]Candle),
+	}
+}
+
+// Add applies one trade to the candles of every interval and returns the
+// updated candles, one per interval.
+func (a *Aggregator) Add(symbol string, price, quantity, amount decimal.Decimal, tradeTime int64) []Candle {
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.series[symbol]; !ok {
+		a.series[symbol] = make(map[string][]Candle)
+	}
+
+	updated := make([]Candle, 0, len(Intervals))
+	for _, interval := range Intervals {
+		candles := a.series[symbol][interval.Name]
+		openTime := interval.openTime(tradeTime)
+
+		// trades almost always land in the last candle
+		index := sort.Search(len(candles), func(i int) bool { return candles[i].OpenTime >= openTime })
+		if index == len(candles) || candles[index].OpenTime != openTime {
+			candle := Candle{
+				Symbol:    symbol,
+				Interval:  interval.Name,
+				OpenTime:  openTime,
+				CloseTime: openTime + interval.seconds() - 1,
+				Open:      price,
+				High:      price,
+				Low:       price,
+				Close:     price,
+			}
+			candles = append(candles, Candle{})
+			copy(candles[index+1:], candles[index:])
+			candles[index] = candle
+		}
+
+		c := &candles[index]
+		if price.GreaterThan(c.High) {
+			c.High = price
+		}
+		if price.LessThan(c.Low) {
+			c.Low = price
+		}
+		if index == len(candles)-1 || c.Trades == 0 {
+			c.Close = price
+		}
+		c.Volume = c.Volume.Add(quantity)
+		c.QuoteVolume = c.QuoteVolume.Add(amount)
+		c.Trades++
+		updated = append(updated, *c)
+
+		if len(candles) > maxCandles {
+			candles = candles[len(candles)-maxCandles:]
+		}
+		a.series[symbol][interval.Name] = candles
+	}
+	return updated
+}
+
+// Query returns up to limit candles whose open time is between start and end
+// (unix seconds, inclusive). Intervals without trades are filled with a flat
+// candle at the previous close and zero volume. A zero end means now; a zero
+// start returns the latest limit candles.
+func (a *Aggregator) Query(symbol string, interval Interval, start, end int64, limit int) []Candle {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	if end <= 0 {
+		end = time.Now().Unix()
+	}
+
+	// Add updates candles in place, so they are only read under the lock
+	a.RLock()
+	defer a.RUnlock()
+
+	stored := a.series[symbol][interval.Name]
+
+	res := []Candle{}
+	if len(stored) == 0 {
+		return res
+	}
+
+	step := interval.seconds()
+	first := interval.openTime(start)
+	if first < stored[0].OpenTime {
+		first = stored[0].OpenTime
+	}
+	last := interval.openTime(end)
+	if start <= 0 {
+		if from := last - int64(limit-1)*step; from > first {
+			first = from
+		}
+	}
+
+	// the candle before the range gives the close to carry forward
+	index := sort.Search(len(stored), func(i int) bool { return stored[i].OpenTime >= first })
+	var prev *Candle
+	if index > 0 {
+		prev = &stored[index-1]
+	}
+
+	for openTime := first; openTime <= last && len(res) < limit; openTime += step {
+		if index < len(stored) && stored[index].OpenTime == openTime {
+			res = append(res, stored[index])
+			prev = &stored[index]
+			index++
+			continue
+		}
+		if prev == nil {
+			continue
+		}
+		res = append(res, Candle{
+			Symbol:    symbol,
+			Interval:  interval.Name,
+			OpenTime:  openTime,
+			CloseTime: openTime + step - 1,
+			Open:      prev.Close,
+			High:      prev.Close,
+			Low:       prev.Close,
+			Close:     prev.Close,
+		})
+	}
+	return res
+}
+
+// Current returns the latest candle of a symbol and interval, if any.
+func (a *Aggregator) Current(symbol string, interval Interval) (Candle, bool) {
+	a.RLock()
+	defer a.RUnlock()
+
+	candles := a.series[symbol][interval.Name]
+	if len(candles) == 0 {
+		return Candle{}, false
+	}
+	return candles[len(candles)-1], true
+}
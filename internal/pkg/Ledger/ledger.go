@@ -0,0 +1,175 @@
+package Ledger
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/User/internal/pkg/Account"
+	"github.com/shopspring/decimal"
+)
+
+// Posting is one balanced entry of the journal: Amount of Asset is credited
+// to From and debited to To. Postings are never changed once recorded.
+type Posting struct {
+	Seq     uint64          `json:"seq"`
+	TradeId string          `json:"trade_id"`
+	Asset   string          `json:"asset"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Amount  decimal.Decimal `json:"amount"`
+	Time    int64           `json:"time"`
+}
+
+// Ledger is an append-only double-entry journal of trade settlements.
+type Ledger struct {
+	postings []Posting
+	seq      uint64
+
+	sync.Mutex
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{
+		postings: make([]Posting, 0),
+	}
+}
+
+// Record appends the postings of one settled trade: base from seller to
+// buyer, quote from buyer to seller and each fee to Account.FeeAccount.
+func (l *Ledger) Record(tradeId string, tradeTime int64, s Account.Settlement) []Posting {
+	l.Lock()
+	defer l.Unlock()
+
+	entries := []Posting{
+		{Asset: s.Base, From: s.Seller, To: s.Buyer, Amount: s.Quantity},
+		{Asset: s.Quote, From: s.Buyer, To: s.Seller, Amount: s.Amount},
+		{Asset: s.Base, From: s.Buyer, To: Account.FeeAccount, Amount: s.BuyerFee},
+		{Asset: s.Quote, From: s.Seller, To: Account.FeeAccount, Amount: s.SellerFee},
+	}
+
+	recorded := make([]Posting, 0, len(entries))
+	for _, p := range entries {
+		if !p.Amount.IsPositive() {
+			continue
+		}
+		l.seq++
+		p.Seq = l.seq
+		p.TradeId = tradeId
+		p.Time = tradeTime
+		l.postings = append(l.postings, p)
+		recorded = append(recorded, p)
+	}
+	return recorded
+}
+
+// Postings returns a copy of every posting, in order.
+func (l *Ledger) Postings() []Posting {
+	l.Lock()
+	defer l.Unlock()
+
+	return append([]Posting{}, l.postings...)
+}
+
+// Restore replaces every posting with saved ones, as Postings returned them.
+func (l *Ledger) Restore(postings []Posting) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.postings = append(make([]Posting, 0, len(postings)), postings...)
+	l.seq = 0
+	if len(postings) > 0 {
+		l.seq = postings[len(postings)-1].Seq
+	}
+}
+
+// Query returns the postings of an account between from and to (unix
+// seconds, inclusive). An empty account or a zero bound matches everything.
+func (l *Ledger) Query(accountId string, from, to int64) []Posting {
+	l.Lock()
+	defer l.Unlock()
+
+	res := []Posting{}
+	for _, p := range l.postings {
+		if accountId != "" && p.From != accountId && p.To != accountId {
+			continue
+		}
+		if (from > 0 && p.Time < from) || (to > 0 && p.Time > to) {
+			continue
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// Balances returns the net effect of every posting, per account and asset.
+func (l *Ledger) Balances() map[string]map[string]decimal.Decimal {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.balances()
+}
+
+func (l *Ledger) balances() map[string]map[string]decimal.Decimal {
+	res := make(map[string]map[string]decimal.Decimal)
+	move := func(accountId, asset string, amount decimal.Decimal) {
+		if _, ok := res[accountId]; !ok {
+			res[accountId] = make(map[string]decimal.Decimal)
+		}
+		res[accountId][asset] = res[accountId][asset].Add(amount)
+	}
+	for _, p := range l.postings {
+		move(p.From, p.Asset, p.Amount.Neg())
+		move(p.To, p.Asset, p.Amount)
+	}
+	return res
+}
+
+// Check verifies the ledger invariants: sequence numbers are gapless, every
+// posting moves a positive amount, and the net of every asset over all
+// accounts is zero. It then reconciles the ledger with accounts: what each
+// account has of an asset must be what it deposited plus its ledger net, so a
+// settlement that was not recorded, or a balance that moved outside of one,
+// is reported.
+func (l *Ledger) Check(accounts *Account.Accounts) error {
+	l.Lock()
+	defer l.Unlock()
+
+	for i, p := range l.postings {
+		if p.Seq != uint64(i+1) {
+			return fmt.Errorf("posting %d has sequence %d", i+1, p.Seq)
+		}
+		if !p.Amount.IsPositive() {
+			return fmt.Errorf("posting %d of trade %s moves %s", p.Seq, p.TradeId, p.Amount)
+		}
+	}
+
+	net := l.balances()
+	totals := make(map[string]decimal.Decimal)
+	for _, assets := range net {
+		for asset, amount := range assets {
+			totals[asset] = totals[asset].Add(amount)
+		}
+	}
+	for asset, total := range totals {
+		if !total.IsZero() {
+			return fmt.Errorf("asset %s is not conserved: net %s", asset, total)
+		}
+	}
+
+	positions := accounts.Positions()
+	for accountId, assets := range positions {
+		for asset, p := range assets {
+			if !p.Total.Sub(p.Deposited).Equal(net[accountId][asset]) {
+				return fmt.Errorf("account %s asset %s: balance %s, deposited %s, ledger net %s", accountId, asset, p.Total, p.Deposited, net[accountId][asset])
+			}
+		}
+	}
+	for accountId, assets := range net {
+		for asset, amount := range assets {
+			if _, ok := positions[accountId][asset]; !ok && !amount.IsZero() {
+				return fmt.Errorf("account %s asset %s: no balance, ledger net %s", accountId, asset, amount)
+			}
+		}
+	}
+	return nil
+}
@@ -4,18 +4,21 @@ import "github.com/shopspring/decimal"
 
 type Order struct {
 	OrderId    string
+	AccountId  string
 	Price      decimal.Decimal
 	Quantity   decimal.Decimal
 	CreateTime int64
+	ExpireTime int64
 	Index      int
 	OrderType  OrderType
 	PriceType  PriceType
 	Amount     decimal.Decimal
 }
 
-func NewOrderItem(pt PriceType, ot OrderType, uniqId string, price, quantity, amount decimal.Decimal, createTime int64) *Order {
+func NewOrderItem(pt PriceType, ot OrderType, uniqId, accountId string, price, quantity, amount decimal.Decimal, createTime int64) *Order {
 	return &Order{
 		OrderId:    uniqId,
+		AccountId:  accountId,
 		Price:      price,
 		Quantity:   quantity,
 		CreateTime: createTime,
@@ -29,6 +32,12 @@ func (o *Order) SetQuantity(qnt decimal.Decimal) {
 	o.Quantity = qnt
 }
 
+// Expired reports whether the order has an expiry time (unix nano) that is
+// not after now. Orders without an expiry time never expire.
+func (o *Order) Expired(now int64) bool {
+	return o.ExpireTime > 0 && o.ExpireTime <= now
+}
+
 type OrderType int
 type PriceType int
 
@@ -0,0 +1,250 @@
+package OrderStore
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"sync"
+
+	. "github.com/User/internal/pkg/Order"
+	"github.com/shopspring/decimal"
+)
+
+type Status string
+
+const (
+	StatusNew             Status = "new"
+	StatusPartiallyFilled Status = "partially_filled"
+	StatusFilled          Status = "filled"
+	StatusCanceled        Status = "canceled"
+	StatusExpired         Status = "expired"
+	// StatusRejected is an order the engine refused before it reached the
+	// book.
+	StatusRejected Status = "rejected"
+)
+
+// Open reports whether an order with this status may still rest in the book.
+func (s Status) Open() bool {
+	return s == StatusNew || s == StatusPartiallyFilled
+}
+
+// Record is the known state of one order. Times are unix nano.
+type Record struct {
+	OrderId           string          `json:"order_id"`
+	AccountId         string          `json:"account_id"`
+	Symbol            string          `json:"symbol"`
+	Side              string          `json:"side"`
+	PriceType         string          `json:"price_type"`
+	Price             decimal.Decimal `json:"price"`
+	Quantity          decimal.Decimal `json:"quantity"`
+	FilledQuantity    decimal.Decimal `json:"filled_quantity"`
+	RemainingQuantity decimal.Decimal `json:"remaining_quantity"`
+	FilledAmount      decimal.Decimal `json:"filled_amount"`
+	AveragePrice      decimal.Decimal `json:"average_price"`
+	Status            Status          `json:"status"`
+	CreateTime        int64           `json:"create_time"`
+	UpdateTime        int64           `json:"update_time"`
+
+	seq uint64
+}
+
+// NewRecord describes an order that was just accepted.
+func NewRecord(symbol string, order Order) Record {
+	side := "bid"
+	if order.OrderType == OrderSell {
+		side = "ask"
+	}
+	priceType := "limit"
+	if order.PriceType == PriceMarket {
+		priceType = "market"
+	}
+	return Record{
+		OrderId:           order.OrderId,
+		AccountId:         order.AccountId,
+		Symbol:            symbol,
+		Side:              side,
+		PriceType:         priceType,
+		Price:             order.Price,
+		Quantity:          order.Quantity,
+		RemainingQuantity: order.Quantity,
+		Status:            StatusNew,
+		CreateTime:        order.CreateTime,
+		UpdateTime:        order.CreateTime,
+	}
+}
+
+// Query selects orders, newest first. Zero values do not filter. From and To
+// are unix nano create times, inclusive. Cursor is the NextCursor of a
+// previous page.
+type Query struct {
+	Symbol    string
+	AccountId string
+	Status    Status
+	From      int64
+	To        int64
+	Limit     int
+	Cursor    string
+}
+
+type Page struct {
+	Orders     []Record `json:"orders"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func (q Query) match(r *Record) bool {
+	if q.Symbol != "" && r.Symbol != q.Symbol {
+		return false
+	}
+	if q.AccountId != "" && r.AccountId != q.AccountId {
+		return false
+	}
+	if q.Status != "" && r.Status != q.Status {
+		return false
+	}
+	if (q.From > 0 && r.CreateTime < q.From) || (q.To > 0 && r.CreateTime > q.To) {
+		return false
+	}
+	return true
+}
+
+// Store keeps the state of every order, updated from engine events.
+type Store struct {
+	orders map[string]*Record
+	log    []*Record
+	seq    uint64
+
+	sync.RWMutex
+}
+
+func NewStore() *Store {
+	return &Store{
+		orders: make(map[string]*Record),
+		log:    make([]*Record, 0),
+	}
+}
+
+// Add records an accepted order. An order that is already known is left
+// untouched.
+func (s *Store) Add(r Record) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.orders[r.OrderId]; ok {
+		return
+	}
+	s.seq++
+	r.seq = s.seq
+	s.orders[r.OrderId] = &r
+	s.log = append(s.log, &r)
+}
+
+// Fill applies one trade of quantity at price to an order.
+func (s *Store) Fill(orderId string, quantity, price decimal.Decimal, time int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	r, ok := s.orders[orderId]
+	if !ok {
+		return
+	}
+	r.FilledQuantity = r.FilledQuantity.Add(quantity)
+	r.FilledAmount = r.FilledAmount.Add(quantity.Mul(price))
+	r.AveragePrice = r.FilledAmount.DivRound(r.FilledQuantity, 8)
+	r.RemainingQuantity = decimal.Max(r.RemainingQuantity.Sub(quantity), decimal.Zero)
+	r.UpdateTime = time
+	if !r.Status.Open() {
+		return
+	}
+	if r.RemainingQuantity.IsPositive() {
+		r.Status = StatusPartiallyFilled
+	} else {
+		r.Status = StatusFilled
+	}
+}
+
+// Amend records a new price and remaining quantity for an open order.
+func (s *Store) Amend(orderId string, price, remaining decimal.Decimal, time int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	r, ok := s.orders[orderId]
+	if !ok || !r.Status.Open() {
+		return
+	}
+	r.Price = price
+	r.RemainingQuantity = remaining
+	r.Quantity = r.FilledQuantity.Add(remaining)
+	r.UpdateTime = time
+}
+
+// Close marks an order as canceled, expired or rejected. The remaining
+// quantity is kept as it was when the order left the book.
+func (s *Store) Close(orderId string, status Status, time int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	r, ok := s.orders[orderId]
+	if !ok || !r.Status.Open() {
+		return
+	}
+	r.Status = status
+	r.UpdateTime = time
+}
+
+func (s *Store) Get(orderId string) (Record, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	r, ok := s.orders[orderId]
+	if !ok {
+		return Record{}, false
+	}
+	return *r, true
+}
+
+func (s *Store) Query(q Query) (Page, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	var before uint64
+	if q.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil {
+			return Page{}, ErrInvalidCursor
+		}
+		if before, err = strconv.ParseUint(string(b), 10, 64); err != nil {
+			return Page{}, ErrInvalidCursor
+		}
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	page := Page{Orders: []Record{}}
+	for i := len(s.log) - 1; i >= 0; i-- {
+		r := s.log[i]
+		if before > 0 && r.seq >= before {
+			continue
+		}
+		if !q.match(r) {
+			continue
+		}
+		if len(page.Orders) == q.Limit {
+			last := page.Orders[len(page.Orders)-1].seq
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(last, 10)))
+			break
+		}
+		page.Orders = append(page.Orders, *r)
+	}
+	return page, nil
+}
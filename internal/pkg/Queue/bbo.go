@@ -0,0 +1,90 @@
+package Queue
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// BBO is the best bid and offer. Prices come from the top of each queue and
+// quantities are the totals resting at those prices. A side without orders
+// has zero price and quantity, and Spread and Mid are only set when both
+// sides have orders. Seq counts the changes of the BBO.
+type BBO struct {
+	Seq         uint64          `json:"seq"`
+	Symbol      string          `json:"symbol"`
+	BidPrice    decimal.Decimal `json:"bid_price"`
+	BidQuantity decimal.Decimal `json:"bid_quantity"`
+	AskPrice    decimal.Decimal `json:"ask_price"`
+	AskQuantity decimal.Decimal `json:"ask_quantity"`
+	Spread      decimal.Decimal `json:"spread"`
+	Mid         decimal.Decimal `json:"mid"`
+	Time        int64           `json:"time"`
+}
+
+func (b BBO) same(o BBO) bool {
+	return b.BidPrice.Equal(o.BidPrice) && b.BidQuantity.Equal(o.BidQuantity) &&
+		b.AskPrice.Equal(o.AskPrice) && b.AskQuantity.Equal(o.AskQuantity)
+}
+
+// BBO returns the current best bid and offer. It does not lock the ticker,
+// so it may be called while consuming the ticker's channels.
+func (t *QueueTicker) BBO() BBO {
+	bbo, _ := t.lastBBO.Load().(BBO)
+	return bbo
+}
+
+// BBOEvents starts the BBO feed and returns its channel, which then receives
+// the BBO after every command that changed it and must be drained.
+func (t *QueueTicker) BBOEvents(buffer int) <-chan BBO {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.chBBO == nil {
+		t.chBBO = make(chan BBO, buffer)
+	}
+	return t.chBBO
+}
+
+// best returns the best price and the exact quantity resting at it.
+func (o *OrderQueue) best() (price, quantity decimal.Decimal) {
+	if o.Pq.Len() == 0 {
+		return
+	}
+	price = o.Top().Price
+
+	o.Lock()
+	defer o.Unlock()
+
+	return price, o.prices[price.String()]
+}
+
+// bestBBO computes the BBO of the book, without Seq and Time.
+func (t *QueueTicker) bestBBO() BBO {
+	bbo := BBO{Symbol: t.Symbol}
+	bbo.BidPrice, bbo.BidQuantity = t.bidQueue.best()
+	bbo.AskPrice, bbo.AskQuantity = t.askQueue.best()
+	if bbo.BidQuantity.IsPositive() && bbo.AskQuantity.IsPositive() {
+		bbo.Spread = bbo.AskPrice.Sub(bbo.BidPrice)
+		bbo.Mid = bbo.AskPrice.Add(bbo.BidPrice).Div(decimal.NewFromInt(2))
+	}
+	return bbo
+}
+
+// checkBBO recomputes the BBO at the end of a command, with the ticker
+// locked, and publishes it if it changed.
+func (t *QueueTicker) checkBBO() {
+	bbo := t.bestBBO()
+	if bbo.same(t.bbo) {
+		return
+	}
+	bbo.Seq = t.bbo.Seq + 1
+	bbo.Time = time.Now().UnixNano()
+	t.bbo = bbo
+	t.lastBBO.Store(bbo)
+
+	if t.chBBO == nil || t.replaying {
+		return
+	}
+	t.notices.push(func() { t.chBBO <- bbo })
+}
@@ -0,0 +1,163 @@
+package Queue
+
+import (
+	"sort"
+	"time"
+
+	. "github.com/User/internal/pkg/Order"
+	"github.com/shopspring/decimal"
+)
+
+type BookEventType string
+
+const (
+	BookAdd     BookEventType = "add"
+	BookModify  BookEventType = "modify"
+	BookDelete  BookEventType = "delete"
+	BookExecute BookEventType = "execute"
+)
+
+// BookEvent is one change to an individual resting order (L3). Seq numbers
+// every event of the ticker in the order the engine applied them.
+//
+// add and modify carry the order's remaining Quantity, delete carries zero.
+// execute carries the traded Quantity and Price of a fill against the resting
+// order OrderId, and comes before the modify or delete that the fill causes.
+// Events never identify the account behind an order.
+type BookEvent struct {
+	Seq          uint64          `json:"seq"`
+	Symbol       string          `json:"symbol"`
+	Type         BookEventType   `json:"type"`
+	OrderId      string          `json:"order_id"`
+	Side         string          `json:"side"`
+	Price        decimal.Decimal `json:"price"`
+	Quantity     decimal.Decimal `json:"quantity"`
+	Priority     int64           `json:"priority"`
+	TradeId      string          `json:"trade_id,omitempty"`
+	TakerOrderId string          `json:"taker_order_id,omitempty"`
+	Time         int64           `json:"time"`
+}
+
+// BookOrder is a resting order as shown by the L3 snapshot. Priority is the
+// time the order took its place in the queue, in unix nano.
+type BookOrder struct {
+	OrderId  string          `json:"order_id"`
+	Side     string          `json:"side"`
+	Price    decimal.Decimal `json:"price"`
+	Quantity decimal.Decimal `json:"quantity"`
+	Priority int64           `json:"priority"`
+}
+
+// BookSnapshot is every resting order as of event Seq. Events after Seq
+// apply on top of it.
+type BookSnapshot struct {
+	Symbol string      `json:"symbol"`
+	Seq    uint64      `json:"seq"`
+	Bids   []BookOrder `json:"bids"`
+	Asks   []BookOrder `json:"asks"`
+}
+
+func side(orderType OrderType) string {
+	if orderType == OrderSell {
+		return "ask"
+	}
+	return "bid"
+}
+
+// BookEvents starts the L3 feed and returns its channel. Events are only
+// produced once it has been called, and the channel must then be drained, as
+// the notifications after a full channel wait for it.
+func (t *QueueTicker) BookEvents(buffer int) <-chan BookEvent {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.chBookEvent == nil {
+		t.chBookEvent = make(chan BookEvent, buffer)
+	}
+	return t.chBookEvent
+}
+
+// BookSeq is the sequence number of the last change to the book.
+func (t *QueueTicker) BookSeq() uint64 {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.bookSeq
+}
+
+// BookSnapshot returns every resting order, each side best price first and
+// then by priority.
+func (t *QueueTicker) BookSnapshot() BookSnapshot {
+	t.Lock()
+	defer t.Unlock()
+
+	return BookSnapshot{
+		Symbol: t.Symbol,
+		Seq:    t.bookSeq,
+		Bids:   bookOrders(*t.bidQueue.Pq, true),
+		Asks:   bookOrders(*t.askQueue.Pq, false),
+	}
+}
+
+func bookOrders(pq PriorityQueue, desc bool) []BookOrder {
+	orders := make([]BookOrder, 0, len(pq))
+	for _, order := range pq {
+		orders = append(orders, BookOrder{
+			OrderId:  order.OrderId,
+			Side:     side(order.OrderType),
+			Price:    order.Price,
+			Quantity: order.Quantity,
+			Priority: order.CreateTime,
+		})
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		if c := orders[i].Price.Cmp(orders[j].Price); c != 0 {
+			return (c > 0) == desc
+		}
+		return orders[i].Priority < orders[j].Priority
+	})
+	return orders
+}
+
+// bookEvent numbers and publishes an event. It runs with the ticker locked,
+// also while replaying, so sequence numbers follow the journal; replayed
+// events are not published.
+func (t *QueueTicker) bookEvent(e BookEvent) {
+	t.bookSeq++
+	if t.chBookEvent == nil || t.replaying {
+		return
+	}
+	e.Seq = t.bookSeq
+	e.Symbol = t.Symbol
+	e.Time = time.Now().UnixNano()
+	t.notices.push(func() { t.chBookEvent <- e })
+}
+
+// bookChange is the OrderQueue hook for orders added, changed or removed.
+func (t *QueueTicker) bookChange(eventType BookEventType, order Order) {
+	quantity := order.Quantity
+	if eventType == BookDelete {
+		quantity = decimal.Zero
+	}
+	t.bookEvent(BookEvent{
+		Type:     eventType,
+		OrderId:  order.OrderId,
+		Side:     side(order.OrderType),
+		Price:    order.Price,
+		Quantity: quantity,
+		Priority: order.CreateTime,
+	})
+}
+
+func (t *QueueTicker) bookExecute(maker Order, takerOrderId, tradeId string, price, quantity decimal.Decimal) {
+	t.bookEvent(BookEvent{
+		Type:         BookExecute,
+		OrderId:      maker.OrderId,
+		Side:         side(maker.OrderType),
+		Price:        price,
+		Quantity:     quantity,
+		Priority:     maker.CreateTime,
+		TradeId:      tradeId,
+		TakerOrderId: takerOrderId,
+	})
+}
@@ -0,0 +1,141 @@
+package Queue
+
+import (
+	"sort"
+
+	. "github.com/User/internal/pkg/Order"
+	"github.com/shopspring/decimal"
+)
+
+// depthPrecision is the number of decimal places depth levels are grouped by.
+const depthPrecision = 2
+
+// depthQuantityPrecision is the number of decimal places depth quantities are
+// shown with.
+const depthQuantityPrecision = 4
+
+// DepthQuantity formats the quantity of a depth level and reports whether the
+// level is shown at all. A level whose quantity rounds to zero is left out of
+// depth, as clients take a zero quantity for a removed level.
+func DepthQuantity(quantity decimal.Decimal) (string, bool) {
+	return quantity.StringFixed(depthQuantityPrecision), !quantity.Round(depthQuantityPrecision).IsZero()
+}
+
+// level is the resting quantity and number of orders at one depth price.
+type level struct {
+	price    decimal.Decimal
+	quantity decimal.Decimal
+	orders   int
+}
+
+// depthBook aggregates one side of the book by price. It is updated on every
+// insert, fill and removal instead of being rebuilt, and keeps its levels
+// sorted best first so the top k levels are read in O(k).
+type depthBook struct {
+	desc   bool
+	index  map[string]*level
+	levels []*level
+}
+
+func newDepthBook(desc bool) *depthBook {
+	return &depthBook{
+		desc:   desc,
+		index:  make(map[string]*level),
+		levels: make([]*level, 0),
+	}
+}
+
+// better reports whether price a comes before price b on this side.
+func (d *depthBook) better(a, b decimal.Decimal) bool {
+	if d.desc {
+		return a.GreaterThan(b)
+	}
+	return a.LessThan(b)
+}
+
+// add changes the level of price by quantity and orders, creating it or
+// removing it as needed.
+func (d *depthBook) add(price, quantity decimal.Decimal, orders int) {
+	price = price.Round(depthPrecision)
+	key := price.String()
+
+	l, ok := d.index[key]
+	if !ok {
+		if orders <= 0 {
+			return
+		}
+		l = &level{price: price}
+		i := sort.Search(len(d.levels), func(i int) bool { return d.better(price, d.levels[i].price) })
+		d.levels = append(d.levels, nil)
+		copy(d.levels[i+1:], d.levels[i:])
+		d.levels[i] = l
+		d.index[key] = l
+	}
+
+	l.quantity = l.quantity.Add(quantity)
+	l.orders += orders
+	if l.orders > 0 {
+		return
+	}
+
+	i := sort.Search(len(d.levels), func(i int) bool { return !d.better(d.levels[i].price, price) })
+	d.levels = append(d.levels[:i], d.levels[i+1:]...)
+	delete(d.index, key)
+}
+
+// top returns the best size shown levels as [price, quantity], or every
+// shown level when size is not positive.
+func (d *depthBook) top(size int) [][2]string {
+	res := [][2]string{}
+	for _, l := range d.levels {
+		if size > 0 && len(res) == size {
+			break
+		}
+		if quantity, ok := DepthQuantity(l.quantity); ok {
+			res = append(res, [2]string{FormatDecimal2String(l.price, depthPrecision), quantity})
+		}
+	}
+	return res
+}
+
+func (d *depthBook) reset(orders []Order) {
+	d.index = make(map[string]*level)
+	d.levels = d.levels[:0]
+	for _, order := range orders {
+		d.add(order.Price, order.Quantity, 1)
+	}
+}
+
+// grouped is top with prices grouped by step: bids are rounded down and asks
+// up to a multiple of step, so a group never shows a better price than the
+// orders in it. Levels are sorted, so each group is a run of adjacent levels.
+func (d *depthBook) grouped(size int, step decimal.Decimal) [][2]string {
+	res := [][2]string{}
+	var price, quantity decimal.Decimal
+	started := false
+	// add appends the group being summed, if it is shown
+	add := func() {
+		if q, ok := DepthQuantity(quantity); started && ok {
+			res = append(res, [2]string{FormatDecimal2String(price, depthPrecision), q})
+		}
+	}
+	for _, l := range d.levels {
+		group := l.price.Div(step)
+		if d.desc {
+			group = group.Floor().Mul(step)
+		} else {
+			group = group.Ceil().Mul(step)
+		}
+		if started && group.Equal(price) {
+			quantity = quantity.Add(l.quantity)
+			continue
+		}
+		add()
+		if size > 0 && len(res) == size {
+			return res
+		}
+		price, quantity, started = group, l.quantity, true
+	}
+	add()
+	return res
+}
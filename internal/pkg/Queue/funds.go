@@ -0,0 +1,85 @@
+package Queue
+
+import (
+	"errors"
+
+	"github.com/User/internal/pkg/Journal"
+	. "github.com/User/internal/pkg/Order"
+	"github.com/User/internal/pkg/Snapshot"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrUnknownOrder = errors.New("unknown order")
+	ErrNoFunds      = errors.New("ticker keeps no funds")
+)
+
+// Funds keeps the money behind the orders of a ticker. The ticker calls it
+// with the ticker locked, as part of the command that causes each change, so
+// the hold of an order always matches what the order has left in the book:
+// a fill is settled before any later command, such as an amend, looks at it.
+// Replaying the journal makes the same calls again, so funds are rebuilt along
+// with the book.
+type Funds interface {
+	// Hold reserves what a new order needs. An order it refuses is not
+	// added to the book.
+	Hold(order Order) error
+	// Amend resizes the hold of a resting order to amend's price and
+	// remaining quantity. An amend it refuses is not applied.
+	Amend(amend Order) error
+	// Settle moves the funds of a trade and returns the fee charged to the
+	// buyer, in base, and to the seller, in quote.
+	Settle(trade TradeResult) (buyerFee, sellerFee decimal.Decimal, err error)
+	// Release returns whatever an order still holds once it left the book.
+	Release(orderId string)
+	Deposit(accountId, asset string, amount decimal.Decimal) error
+	SetFeeRate(rate decimal.Decimal)
+	// Save adds the funds to a snapshot of the ticker, Load puts them back.
+	Save(s *Snapshot.Snapshot)
+	Load(s Snapshot.Snapshot)
+}
+
+// SetFunds makes the ticker hold, settle and release the funds of its orders
+// through f.
+func (t *QueueTicker) SetFunds(f Funds) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.funds = f
+}
+
+// Deposit credits amount of asset to an account. It is journaled like an
+// order, so replaying the journal funds the same accounts.
+func (t *QueueTicker) Deposit(accountId, asset string, amount decimal.Decimal) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.funds == nil {
+		return ErrNoFunds
+	}
+	if err := t.record(Journal.Command{Type: Journal.CommandDeposit, AccountId: accountId, Asset: asset, Amount: amount}); err != nil {
+		return err
+	}
+	return t.funds.Deposit(accountId, asset, amount)
+}
+
+// SetFeeRate sets the fee rate of the trades settled from now on.
+func (t *QueueTicker) SetFeeRate(rate decimal.Decimal) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.funds == nil {
+		return ErrNoFunds
+	}
+	if err := t.record(Journal.Command{Type: Journal.CommandFeeRate, Amount: rate}); err != nil {
+		return err
+	}
+	t.funds.SetFeeRate(rate)
+	return nil
+}
+
+func (t *QueueTicker) releaseFunds(orderId string) {
+	if t.funds != nil {
+		t.funds.Release(orderId)
+	}
+}
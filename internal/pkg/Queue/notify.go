@@ -0,0 +1,44 @@
+package Queue
+
+import "sync"
+
+// notifier sends the ticker's notifications, trades, cancels, expiries,
+// book events and BBOs, in the order they were made. Commands queue them
+// with the ticker locked and never wait on a channel, so a consumer that is
+// slow, or that calls back into the ticker, cannot hold up matching.
+type notifier struct {
+	queue []func()
+	wake  chan struct{}
+	sync.Mutex
+}
+
+func newNotifier() *notifier {
+	n := &notifier{wake: make(chan struct{}, 1)}
+	go n.run()
+	return n
+}
+
+// push queues a send.
+func (n *notifier) push(send func()) {
+	n.Lock()
+	n.queue = append(n.queue, send)
+	n.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *notifier) run() {
+	for range n.wake {
+		n.Lock()
+		queue := n.queue
+		n.queue = nil
+		n.Unlock()
+
+		for _, send := range queue {
+			send()
+		}
+	}
+}
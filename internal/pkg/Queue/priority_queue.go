@@ -10,17 +10,32 @@ import (
 
 type PriorityQueue []Order
 
+// OrderQueue is one side of the book. The mutex guards depth and prices,
+// which are kept in step with Pq by En, Remove and SetQuantity. onChange,
+// when set, is told about every order they add, change or remove.
 type OrderQueue struct {
 	Pq    *PriorityQueue
-	Depth [][2]string
+	depth *depthBook
+	// prices is the exact quantity resting at each price, unlike depth,
+	// whose levels are rounded to depthPrecision.
+	prices   map[string]decimal.Decimal
+	onChange func(BookEventType, Order)
 	sync.Mutex
 }
 
 func (p PriorityQueue) Len() int      { return len(p) }
 func (p PriorityQueue) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 
+// Less puts the best price first, the lowest ask or the highest bid, and the
+// oldest order first within a price.
 func (p PriorityQueue) Less(i, j int) bool {
-	return (p[i].Price.Cmp(p[j].Price) != -1) || (p[i].Price.Cmp(p[j].Price) == 0 && p[i].CreateTime > p[j].CreateTime)
+	if c := p[i].Price.Cmp(p[j].Price); c != 0 {
+		if p[i].OrderType == OrderSell {
+			return c < 0
+		}
+		return c > 0
+	}
+	return p[i].CreateTime < p[j].CreateTime
 }
 func (p *PriorityQueue) Push(x interface{}) {
 	*p = append(*p, x.(Order))
@@ -38,10 +53,18 @@ func (p PriorityQueue) SetQuantity(index int, Quantity decimal.Decimal) {
 }
 
 func NewQueue() *OrderQueue {
+	return NewSideQueue(OrderBuy)
+}
+
+// NewSideQueue creates the queue of one side; its depth is sorted best price
+// first for that side.
+func NewSideQueue(side OrderType) *OrderQueue {
 	pq := make(PriorityQueue, 0)
 	heap.Init(&pq)
 	queue := OrderQueue{
-		Pq: &pq,
+		Pq:     &pq,
+		depth:  newDepthBook(side != OrderSell),
+		prices: make(map[string]decimal.Decimal),
 	}
 	return &queue
 }
@@ -85,8 +108,96 @@ func (o *OrderQueue) GetOrderByPrice(Price decimal.Decimal) (bool, Order) {
 	return false, order
 }
 
+// settle writes the remaining quantity of an incoming order back to its
+// resting copy once matching is done, removing it when nothing is left.
+func (o *OrderQueue) settle(item Order) {
+	isExist, index := o.GetIndexByUnId(item.OrderId)
+	if !isExist {
+		return
+	}
+	if item.Quantity.Equal(decimal.Zero) {
+		o.Remove(index)
+	} else {
+		o.SetQuantity(index, item.Quantity)
+	}
+}
+
 func (o *OrderQueue) Remove(index int) {
-	heap.Remove(o.Pq, index)
+	order := heap.Remove(o.Pq, index).(Order)
+
+	o.Lock()
+	o.depth.add(order.Price, order.Quantity.Neg(), -1)
+	o.addPrice(order.Price, order.Quantity.Neg())
+	o.Unlock()
+	o.changed(BookDelete, order)
+}
+
+// SetQuantity changes the remaining quantity of the order at index.
+func (o *OrderQueue) SetQuantity(index int, quantity decimal.Decimal) {
+	order := o.Get(index)
+	if order.Quantity.Equal(quantity) {
+		return
+	}
+	o.Pq.SetQuantity(index, quantity)
+
+	o.Lock()
+	o.depth.add(order.Price, quantity.Sub(order.Quantity), 0)
+	o.addPrice(order.Price, quantity.Sub(order.Quantity))
+	o.Unlock()
+	o.changed(BookModify, o.Get(index))
+}
+
+func (o *OrderQueue) changed(eventType BookEventType, order Order) {
+	if o.onChange != nil {
+		o.onChange(eventType, order)
+	}
+}
+
+// Depth returns the best size price levels as [price, quantity], or every
+// level when size is not positive.
+func (o *OrderQueue) Depth(size int) [][2]string {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.depth.top(size)
+}
+
+// GroupedDepth is Depth with prices grouped by step.
+func (o *OrderQueue) GroupedDepth(size int, step decimal.Decimal) [][2]string {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.depth.grouped(size, step)
+}
+
+// reset replaces the queue with a raw heap slice, as taken by a snapshot.
+// The heap is re-established in case the slice was written under another
+// ordering.
+func (o *OrderQueue) reset(orders []Order) {
+	pq := PriorityQueue(orders)
+	heap.Init(&pq)
+
+	o.Lock()
+	defer o.Unlock()
+
+	o.Pq = &pq
+	o.depth.reset(orders)
+	o.prices = make(map[string]decimal.Decimal)
+	for _, order := range orders {
+		o.addPrice(order.Price, order.Quantity)
+	}
+}
+
+// addPrice changes the quantity resting at price, forgetting prices left
+// empty. It runs locked.
+func (o *OrderQueue) addPrice(price, quantity decimal.Decimal) {
+	key := price.String()
+	total := o.prices[key].Add(quantity)
+	if total.IsPositive() {
+		o.prices[key] = total
+	} else {
+		delete(o.prices, key)
+	}
 }
 
 func (o *OrderQueue) Get(index int) Order {
@@ -99,8 +210,14 @@ func (o *OrderQueue) Top() Order {
 
 func (p *OrderQueue) En(e Order) {
 	heap.Push(p.Pq, e)
+
+	p.Lock()
+	p.depth.add(e.Price, e.Quantity, 1)
+	p.addPrice(e.Price, e.Quantity)
+	p.Unlock()
+	p.changed(BookAdd, e)
 }
 
 func (p *OrderQueue) De() {
-	heap.Pop(p.Pq)
+	p.Remove(0)
 }
@@ -1,38 +1,97 @@
 package Queue
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/User/internal/pkg/Journal"
 	. "github.com/User/internal/pkg/Order"
+	"github.com/User/internal/pkg/Snapshot"
 	"github.com/shopspring/decimal"
 )
 
 type TradeResult struct {
+	TradeId       string          `json:"trade_id"`
 	Symbol        string          `json:"symbol"`
 	AskOrderId    string          `json:"ask_order_id"`
 	BidOrderId    string          `json:"bid_order_id"`
+	AskAccountId  string          `json:"ask_account_id"`
+	BidAccountId  string          `json:"bid_account_id"`
 	TradeQuantity decimal.Decimal `json:"trade_quantity"`
 	TradePrice    decimal.Decimal `json:"trade_price"`
 	TradeAmount   decimal.Decimal `json:"trade_amount"`
 	TradeTime     int64           `json:"trade_time"`
+	BuyerFee      decimal.Decimal `json:"buyer_fee"`
+	SellerFee     decimal.Decimal `json:"seller_fee"`
 }
 
+type TradingStatus string
+
+const (
+	StatusTrading TradingStatus = "trading"
+	// StatusHalted refuses new and amended orders; cancels still go through.
+	StatusHalted TradingStatus = "halted"
+)
+
+var ErrHalted = errors.New("trading halted")
+
+var ErrDepthStep = errors.New("unsupported depth step")
+
+// depthSteps is how many depth steps are offered: the tick size and each
+// power of ten above it.
+const depthSteps = 4
+
+// quantityPrecision is the number of decimal places a market buy quantity is
+// truncated to when it is limited by the order's quote budget.
+const quantityPrecision = 8
+
 type QueueTicker struct {
 	Symbol         string
 	ChOrder        chan Order
 	ChTradeResult  chan TradeResult
 	ChCancelResult chan string
+	ChExpireResult chan string
 	latestPrice    decimal.Decimal
+	tradeSeq       uint64
+	status         TradingStatus
 	askQueue       *OrderQueue
 	bidQueue       *OrderQueue
 
+	// funds, when set, holds and settles the funds of every order.
+	funds Funds
+
+	// notices sends to the channels above, and to those of the L3 and BBO
+	// feeds, once the command that made them has released the ticker.
+	notices *notifier
+
+	// journal, when set, receives every command before it is applied.
+	// While replaying, commands are applied without being journaled again
+	// and without notifications, but funds still move.
+	journal    *Journal.Journal
+	journalSeq uint64
+	replaying  bool
+
+	// bookSeq numbers the L3 events, which go to chBookEvent once the feed
+	// is started with BookEvents.
+	bookSeq     uint64
+	chBookEvent chan BookEvent
+
+	// bbo is the last best bid and offer, sent to chBBO on every change
+	// once the feed is started with BBOEvents. lastBBO is a copy for
+	// readers that must not lock the ticker.
+	bbo     BBO
+	lastBBO atomic.Value
+	chBBO   chan BBO
+
 	sync.Mutex
 }
 
-func (t *QueueTicker) PushNewOrder(item Order) {
-	t.handlerNewOrder(item)
+func (t *QueueTicker) PushNewOrder(item Order) error {
+	return t.handlerNewOrder(item)
 }
 
 func NewQueueTicker(symbol string) *QueueTicker {
@@ -41,11 +100,15 @@ func NewQueueTicker(symbol string) *QueueTicker {
 		ChTradeResult:  make(chan TradeResult, 10),
 		ChOrder:        make(chan Order),
 		ChCancelResult: make(chan string, 10),
-		askQueue:       NewQueue(),
-		bidQueue:       NewQueue(),
+		ChExpireResult: make(chan string, 10),
+		status:         StatusTrading,
+		askQueue:       NewSideQueue(OrderSell),
+		bidQueue:       NewSideQueue(OrderBuy),
+		notices:        newNotifier(),
 	}
-	go t.depthTicker(t.askQueue)
-	go t.depthTicker(t.bidQueue)
+	t.askQueue.onChange = t.bookChange
+	t.bidQueue.onChange = t.bookChange
+	go t.expireTicker()
 	go t.matching()
 	return t
 }
@@ -60,10 +123,221 @@ func (t *QueueTicker) BidLen() int {
 	return t.bidQueue.Pq.Len()
 }
 
-func (t *QueueTicker) handlerNewOrder(newOrder Order) {
+func (t *QueueTicker) LatestPrice() decimal.Decimal {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.latestPrice
+}
+
+func (t *QueueTicker) queue(orderType OrderType) *OrderQueue {
+	if orderType == OrderSell {
+		return t.askQueue
+	}
+	return t.bidQueue
+}
+
+func (t *QueueTicker) Status() TradingStatus {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.status
+}
+
+func (t *QueueTicker) SetStatus(status TradingStatus) error {
+	if status != StatusTrading && status != StatusHalted {
+		return fmt.Errorf("unknown trading status %q", status)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if err := t.record(Journal.Command{Type: Journal.CommandStatus, Status: string(status)}); err != nil {
+		return err
+	}
+	t.status = status
+	return nil
+}
+
+// JournalSeq returns the sequence number of the last command applied.
+func (t *QueueTicker) JournalSeq() uint64 {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.journalSeq
+}
+
+// SyncJournal flushes every journaled command to disk, whatever the sync
+// policy of the journal.
+func (t *QueueTicker) SyncJournal() error {
+	t.Lock()
+	j := t.journal
+	t.Unlock()
+
+	if j == nil {
+		return nil
+	}
+	return j.Sync()
+}
+
+// Snapshot captures the full ticker state at the current journal sequence.
+func (t *QueueTicker) Snapshot() Snapshot.Snapshot {
 	t.Lock()
 	defer t.Unlock()
 
+	s := Snapshot.Snapshot{
+		Symbol:      t.Symbol,
+		JournalSeq:  t.journalSeq,
+		TradeSeq:    t.tradeSeq,
+		BookSeq:     t.bookSeq,
+		BBOSeq:      t.bbo.Seq,
+		LatestPrice: t.latestPrice,
+		Status:      string(t.status),
+		Asks:        append([]Order{}, *t.askQueue.Pq...),
+		Bids:        append([]Order{}, *t.bidQueue.Pq...),
+		CreateTime:  time.Now().UnixNano(),
+	}
+	if t.funds != nil {
+		t.funds.Save(&s)
+	}
+	return s
+}
+
+// Restore replaces the ticker state with a snapshot. Commands after
+// s.JournalSeq are then replayed with Replay.
+func (t *QueueTicker) Restore(s Snapshot.Snapshot) error {
+	if s.Symbol != t.Symbol {
+		return fmt.Errorf("snapshot of %s cannot restore %s", s.Symbol, t.Symbol)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.askQueue.reset(append([]Order{}, s.Asks...))
+	t.bidQueue.reset(append([]Order{}, s.Bids...))
+	if t.funds != nil {
+		t.funds.Load(s)
+	}
+
+	t.journalSeq = s.JournalSeq
+	t.tradeSeq = s.TradeSeq
+	t.bookSeq = s.BookSeq
+	t.latestPrice = s.LatestPrice
+	t.status = TradingStatus(s.Status)
+	if t.status == "" {
+		t.status = StatusTrading
+	}
+
+	// the restored BBO is the one the snapshot was taken at, not a change
+	bbo := t.bestBBO()
+	bbo.Seq = s.BBOSeq
+	bbo.Time = time.Now().UnixNano()
+	t.bbo = bbo
+	t.lastBBO.Store(bbo)
+	return nil
+}
+
+// Orders returns a copy of every resting order, asks first.
+func (t *QueueTicker) Orders() []Order {
+	t.Lock()
+	defer t.Unlock()
+
+	orders := append([]Order{}, *t.askQueue.Pq...)
+	return append(orders, *t.bidQueue.Pq...)
+}
+
+// SetJournal makes the ticker append every command to j before applying it.
+func (t *QueueTicker) SetJournal(j *Journal.Journal) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.journal = j
+}
+
+// Replay applies, in order, every journaled command after seq. Replaying the
+// same journal always rebuilds the same books, latest price, trade ids and
+// funds. Orders and amends the funds refused when they were journaled are
+// refused again.
+func (t *QueueTicker) Replay(j *Journal.Journal, after uint64) error {
+	t.Lock()
+	defer t.Unlock()
+
+	t.replaying = true
+	defer func() { t.replaying = false }()
+
+	return j.Replay(after, func(cmd Journal.Command) error {
+		switch cmd.Type {
+		case Journal.CommandNew:
+			if t.funds != nil && t.funds.Hold(cmd.Order) != nil {
+				break
+			}
+			t.applyNewOrder(cmd.Order)
+		case Journal.CommandCancel, Journal.CommandExpire:
+			t.removeOrder(cmd.Order.OrderType, cmd.Order.OrderId)
+		case Journal.CommandAmend:
+			if t.funds != nil && t.funds.Amend(cmd.Order) != nil {
+				break
+			}
+			t.applyAmend(cmd.Order)
+		case Journal.CommandStatus:
+			t.status = TradingStatus(cmd.Status)
+		case Journal.CommandDeposit:
+			if t.funds != nil {
+				t.funds.Deposit(cmd.AccountId, cmd.Asset, cmd.Amount)
+			}
+		case Journal.CommandFeeRate:
+			if t.funds != nil {
+				t.funds.SetFeeRate(cmd.Amount)
+			}
+		default:
+			return fmt.Errorf("journal command %d: unknown type %q", cmd.Seq, cmd.Type)
+		}
+		t.journalSeq = cmd.Seq
+		return nil
+	})
+}
+
+// record appends a command to the journal, if any. It must be called with the
+// ticker locked, so that the journal order is the order commands are applied.
+// Without a journal commands are still numbered, so snapshots can tell when
+// the state changed.
+func (t *QueueTicker) record(cmd Journal.Command) error {
+	if t.replaying {
+		return nil
+	}
+	if t.journal == nil {
+		t.journalSeq++
+		return nil
+	}
+	seq, err := t.journal.Append(cmd)
+	if err != nil {
+		return err
+	}
+	t.journalSeq = seq
+	return nil
+}
+
+func (t *QueueTicker) handlerNewOrder(newOrder Order) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.status == StatusHalted {
+		return ErrHalted
+	}
+	if t.funds != nil {
+		if err := t.funds.Hold(newOrder); err != nil {
+			return err
+		}
+	}
+	if err := t.record(Journal.Command{Type: Journal.CommandNew, Order: newOrder}); err != nil {
+		t.releaseFunds(newOrder.OrderId)
+		return err
+	}
+	t.applyNewOrder(newOrder)
+	return nil
+}
+
+func (t *QueueTicker) applyNewOrder(newOrder Order) {
 	if newOrder.OrderType == OrderSell {
 		t.askQueue.En(newOrder)
 		t.Sell(newOrder)
@@ -71,45 +345,211 @@ func (t *QueueTicker) handlerNewOrder(newOrder Order) {
 		t.bidQueue.En(newOrder)
 		t.Buy(newOrder)
 	}
+
+	// a market order never rests: whatever could not fill is canceled
+	if newOrder.PriceType == PriceMarket {
+		queue := t.queue(newOrder.OrderType)
+		if isExist, index := queue.GetIndexByUnId(newOrder.OrderId); isExist {
+			queue.Remove(index)
+			t.releaseFunds(newOrder.OrderId)
+			if !t.replaying {
+				t.notifyCancel(newOrder.OrderId)
+			}
+		}
+	}
+	t.checkBBO()
 }
 
+// GetAskDepth returns the best size ask levels, or all of them when size is
+// not positive. Depth is maintained as the book changes, so it is never stale.
 func (t *QueueTicker) GetAskDepth(size int) [][2]string {
-	return t.depth(t.askQueue, size)
+	return t.askQueue.Depth(size)
 }
 
 func (t *QueueTicker) GetBidDepth(size int) [][2]string {
-	return t.depth(t.bidQueue, size)
+	return t.bidQueue.Depth(size)
+}
+
+// TickSize is the price increment of the symbol, which is also the finest
+// depth step.
+func (t *QueueTicker) TickSize() decimal.Decimal {
+	return decimal.New(1, -depthPrecision)
+}
+
+// DepthSteps returns the price steps depth can be grouped by, finest first.
+func (t *QueueTicker) DepthSteps() []decimal.Decimal {
+	steps := make([]decimal.Decimal, 0, depthSteps)
+	step := t.TickSize()
+	for i := 0; i < depthSteps; i++ {
+		steps = append(steps, step)
+		step = step.Shift(1)
+	}
+	return steps
 }
 
-func (t *QueueTicker) depth(queue *OrderQueue, size int) [][2]string {
-	queue.Lock()
-	defer queue.Unlock()
+func (t *QueueTicker) validDepthStep(step decimal.Decimal) bool {
+	for _, s := range t.DepthSteps() {
+		if s.Equal(step) {
+			return true
+		}
+	}
+	return false
+}
 
-	max := len(queue.Depth)
-	if size <= 0 || size > max {
-		size = max
+// GetAskDepthStep is GetAskDepth with prices grouped by step, rounding up.
+// step must be one of DepthSteps.
+func (t *QueueTicker) GetAskDepthStep(size int, step decimal.Decimal) ([][2]string, error) {
+	if !t.validDepthStep(step) {
+		return nil, ErrDepthStep
 	}
+	return t.askQueue.GroupedDepth(size, step), nil
+}
 
-	return queue.Depth[0:size]
+// GetBidDepthStep is GetBidDepth with prices grouped by step, rounding down.
+func (t *QueueTicker) GetBidDepthStep(size int, step decimal.Decimal) ([][2]string, error) {
+	if !t.validDepthStep(step) {
+		return nil, ErrDepthStep
+	}
+	return t.bidQueue.GroupedDepth(size, step), nil
 }
 
 func (t *QueueTicker) matching() {
-	for {
-		select {
-		case newOrder := <-t.ChOrder:
-			go t.handlerNewOrder(newOrder)
-		default:
+	for newOrder := range t.ChOrder {
+		go func(newOrder Order) {
+			if err := t.handlerNewOrder(newOrder); err != nil {
+				// the order never reached the book, report it like a cancel
+				log.Printf("%s new order %s: %v", t.Symbol, newOrder.OrderId, err)
+				t.notifyCancel(newOrder.OrderId)
+			}
+		}(newOrder)
+	}
+}
+
+// CancelOrder removes a resting order from the book and reports whether it
+// was found. Only orders that were actually removed are sent on
+// ChCancelResult.
+func (t *QueueTicker) CancelOrder(orderType OrderType, uniq string) bool {
+	t.Lock()
+	isExist, _ := t.queue(orderType).GetIndexByUnId(uniq)
+	if isExist {
+		if err := t.record(Journal.Command{Type: Journal.CommandCancel, Order: Order{OrderId: uniq, OrderType: orderType}}); err != nil {
+			log.Printf("%s cancel order %s: %v", t.Symbol, uniq, err)
+			isExist = false
+		} else {
+			t.removeOrder(orderType, uniq)
+			t.notifyCancel(uniq)
 		}
 	}
+	t.Unlock()
+
+	return isExist
 }
 
-func (t *QueueTicker) CancelOrder(orderType OrderType, uniq string) {
-	if orderType == OrderSell {
-		t.askQueue.Remove(t.askQueue.GetIndexById(uniq))
-	} else {
-		t.bidQueue.Remove(t.bidQueue.GetIndexById(uniq))
+func (t *QueueTicker) notifyCancel(orderId string) {
+	t.notices.push(func() { t.ChCancelResult <- orderId })
+}
+
+func (t *QueueTicker) removeOrder(orderType OrderType, uniq string) {
+	queue := t.queue(orderType)
+	if isExist, index := queue.GetIndexByUnId(uniq); isExist {
+		queue.Remove(index)
+		t.releaseFunds(uniq)
+	}
+	t.checkBBO()
+}
+
+// AmendOrder changes the price and remaining quantity of a resting limit
+// order of amend.AccountId. Lowering the quantity at the same price keeps the
+// order's time priority; any other change re-queues it with amend.CreateTime
+// as its new priority and matches it like a new order. It returns
+// ErrUnknownOrder when there is no such order, or the error of the funds the
+// amended order needs.
+func (t *QueueTicker) AmendOrder(amend Order) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.status == StatusHalted {
+		return ErrHalted
+	}
+	isExist, index := t.queue(amend.OrderType).GetIndexByUnId(amend.OrderId)
+	if !isExist {
+		return ErrUnknownOrder
+	}
+	order := t.queue(amend.OrderType).Get(index)
+	if order.PriceType != PriceLimit || order.AccountId != amend.AccountId {
+		return ErrUnknownOrder
+	}
+	if t.funds != nil {
+		if err := t.funds.Amend(amend); err != nil {
+			return err
+		}
+	}
+	if err := t.record(Journal.Command{Type: Journal.CommandAmend, Order: amend}); err != nil {
+		if t.funds != nil {
+			// the hold goes back to the order as it still is; the funds
+			// the amend released are not used by anything else meanwhile
+			t.funds.Amend(order)
+		}
+		return err
+	}
+	t.applyAmend(amend)
+	return nil
+}
+
+func (t *QueueTicker) applyAmend(amend Order) {
+	queue := t.queue(amend.OrderType)
+	isExist, index := queue.GetIndexByUnId(amend.OrderId)
+	if !isExist {
+		return
+	}
+
+	order := queue.Get(index)
+	if order.Price.Equal(amend.Price) && amend.Quantity.Cmp(order.Quantity) <= 0 {
+		queue.SetQuantity(index, amend.Quantity)
+		t.checkBBO()
+		return
+	}
+
+	queue.Remove(index)
+	order.Price = amend.Price
+	order.Quantity = amend.Quantity
+	order.CreateTime = amend.CreateTime
+	t.applyNewOrder(order)
+}
+
+func (t *QueueTicker) expireTicker() {
+	ticker := time.NewTicker(time.Second)
+
+	for {
+		<-ticker.C
+		t.expireOrders(time.Now().UnixNano())
+	}
+}
+
+// expireOrders removes every resting order whose expiry time has passed and
+// sends their ids on ChExpireResult. Expiry depends on the wall clock, so it
+// is journaled like a cancel to keep replays deterministic.
+func (t *QueueTicker) expireOrders(now int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, queue := range []*OrderQueue{t.askQueue, t.bidQueue} {
+		orders := []Order{}
+		for _, element := range *queue.Pq {
+			if element.Expired(now) {
+				orders = append(orders, element)
+			}
+		}
+		for _, order := range orders {
+			if err := t.record(Journal.Command{Type: Journal.CommandExpire, Order: Order{OrderId: order.OrderId, OrderType: order.OrderType}}); err != nil {
+				log.Printf("%s expire order %s: %v", t.Symbol, order.OrderId, err)
+				continue
+			}
+			t.removeOrder(order.OrderType, order.OrderId)
+			orderId := order.OrderId
+			t.notices.push(func() { t.ChExpireResult <- orderId })
+		}
 	}
-	t.ChCancelResult <- uniq
 }
 
 func (t *QueueTicker) Buy(item Order) {
@@ -120,21 +560,23 @@ func (t *QueueTicker) Buy(item Order) {
 			}
 
 			if item.PriceType == PriceLimit {
-				var isExist, index = t.askQueue.GetIndexByPrice(item.Price)
+				// a bid takes every ask priced at or below it, best first,
+				// at the ask's price
+				var order = t.askQueue.Top()
+				if order.Price.GreaterThan(item.Price) {
+					return false
+				}
+				var isExist, index = t.askQueue.GetIndexByUnId(order.OrderId)
 				if !isExist {
 					return false
 				}
-				var order = t.askQueue.Get(index)
-				curTradeQty := decimal.Zero
-				if order.Quantity.Cmp(item.Quantity) <= 0 {
-					curTradeQty = order.Quantity
-					var _, index = t.askQueue.GetIndexByUnId(order.OrderId)
+				curTradeQty := decimal.Min(order.Quantity, item.Quantity)
+				t.sendTradeResultNotify(order, item, OrderBuy, order.Price, curTradeQty)
+				if curTradeQty.Equal(order.Quantity) {
 					t.askQueue.Remove(index)
 				} else {
-					curTradeQty = item.Quantity
-					t.askQueue.Pq.SetQuantity(index, order.Quantity.Sub(item.Quantity))
+					t.askQueue.SetQuantity(index, order.Quantity.Sub(curTradeQty))
 				}
-				t.sendTradeResultNotify(order, item, order.Price, curTradeQty)
 				item.Quantity = item.Quantity.Sub(curTradeQty)
 
 				return true
@@ -147,38 +589,53 @@ func (t *QueueTicker) Buy(item Order) {
 					return false
 				}
 
-				curTradeQty := decimal.Zero
-				if ask.Quantity.Cmp(item.Quantity) <= 0 {
-					curTradeQty = ask.Quantity
-					t.askQueue.Remove(t.askQueue.GetIndexById(ask.OrderId))
+				curTradeQty := decimal.Min(ask.Quantity, item.Quantity)
+				budgeted := item.Amount.IsPositive() && ask.Price.IsPositive()
+				if budgeted {
+					// a market buy may not spend more than its quote budget
+					curTradeQty = decimal.Min(curTradeQty, item.Amount.Div(ask.Price).Truncate(quantityPrecision))
+					if !curTradeQty.IsPositive() {
+						return false
+					}
+				}
+
+				t.sendTradeResultNotify(ask, item, OrderBuy, ask.Price, curTradeQty)
+				if curTradeQty.Equal(ask.Quantity) {
+					t.askQueue.Remove(index)
 				} else {
-					curTradeQty = item.Quantity
-					t.askQueue.Pq.SetQuantity(index, ask.Quantity.Sub(item.Quantity))
+					t.askQueue.SetQuantity(index, ask.Quantity.Sub(curTradeQty))
 				}
 
-				t.sendTradeResultNotify(ask, item, ask.Price, curTradeQty)
 				item.Quantity = item.Quantity.Sub(curTradeQty)
+				if budgeted {
+					item.Amount = item.Amount.Sub(curTradeQty.Mul(ask.Price))
+				}
 				return true
 			}
 
 		}()
 
 		if !ok {
-			time.Sleep(time.Duration(200) * time.Millisecond)
 			break
 		}
 	}
 
-	if t.askQueue.Pq.Len() != 0 && item.Quantity.Equal(decimal.Zero) {
-		defer t.bidQueue.Remove(t.bidQueue.GetIndexById(item.OrderId))
-	}
+	t.bidQueue.settle(item)
 }
 
-func (t *QueueTicker) sendTradeResultNotify(ask, bid Order, price, tradeQty decimal.Decimal) {
+// sendTradeResultNotify reports a trade between ask and bid, where taker is
+// the side of the incoming order. It is called before the resting order is
+// updated, so the execute event comes ahead of the change it causes.
+func (t *QueueTicker) sendTradeResultNotify(ask, bid Order, taker OrderType, price, tradeQty decimal.Decimal) {
+	t.tradeSeq++
+
 	tradelog := TradeResult{}
+	tradelog.TradeId = fmt.Sprintf("%s-%d", t.Symbol, t.tradeSeq)
 	tradelog.Symbol = t.Symbol
 	tradelog.AskOrderId = ask.OrderId
 	tradelog.BidOrderId = bid.OrderId
+	tradelog.AskAccountId = ask.AccountId
+	tradelog.BidAccountId = bid.AccountId
 	tradelog.TradeQuantity = tradeQty
 	tradelog.TradePrice = price
 	tradelog.TradeTime = time.Now().Unix()
@@ -186,11 +643,27 @@ func (t *QueueTicker) sendTradeResultNotify(ask, bid Order, price, tradeQty deci
 
 	t.latestPrice = price
 
+	if t.funds != nil {
+		var err error
+		if tradelog.BuyerFee, tradelog.SellerFee, err = t.funds.Settle(tradelog); err != nil {
+			log.Printf("%s settle %s: %v", t.Symbol, tradelog.TradeId, err)
+		}
+	}
+
+	maker, takerOrder := ask, bid
+	if taker == OrderSell {
+		maker, takerOrder = bid, ask
+	}
+	t.bookExecute(maker, takerOrder.OrderId, tradelog.TradeId, price, tradeQty)
+
 	/*if Debug {
 		logrus.Infof("%s tradelog: %+v", t.Symbol, tradelog)
 	}*/
 
-	t.ChTradeResult <- tradelog
+	if t.replaying {
+		return
+	}
+	t.notices.push(func() { t.ChTradeResult <- tradelog })
 }
 
 func (t *QueueTicker) Sell(item Order) {
@@ -201,21 +674,23 @@ func (t *QueueTicker) Sell(item Order) {
 			}
 
 			if item.PriceType == PriceLimit {
-				var isExist, index = t.bidQueue.GetIndexByPrice(item.Price)
+				// an ask takes every bid priced at or above it, best first,
+				// at the bid's price
+				var order = t.bidQueue.Top()
+				if order.Price.LessThan(item.Price) {
+					return false
+				}
+				var isExist, index = t.bidQueue.GetIndexByUnId(order.OrderId)
 				if !isExist {
 					return false
 				}
-				var order = t.bidQueue.Get(index)
-				curTradeQty := decimal.Zero
-				if order.Quantity.Cmp(item.Quantity) <= 0 {
-					curTradeQty = order.Quantity
-					var _, a = t.bidQueue.GetIndexByUnId(order.OrderId)
-					t.bidQueue.Remove(a)
+				curTradeQty := decimal.Min(order.Quantity, item.Quantity)
+				t.sendTradeResultNotify(item, order, OrderSell, order.Price, curTradeQty)
+				if curTradeQty.Equal(order.Quantity) {
+					t.bidQueue.Remove(index)
 				} else {
-					curTradeQty = item.Quantity
-					t.bidQueue.Pq.SetQuantity(index, order.Quantity.Sub(item.Quantity))
+					t.bidQueue.SetQuantity(index, order.Quantity.Sub(curTradeQty))
 				}
-				t.sendTradeResultNotify(order, item, order.Price, curTradeQty)
 				item.Quantity = item.Quantity.Sub(curTradeQty)
 
 				return true
@@ -228,16 +703,14 @@ func (t *QueueTicker) Sell(item Order) {
 					return false
 				}
 
-				curTradeQty := decimal.Zero
-				if bid.Quantity.Cmp(item.Quantity) <= 0 {
-					curTradeQty = bid.Quantity
-					t.bidQueue.Remove(t.bidQueue.GetIndexById(bid.OrderId))
+				curTradeQty := decimal.Min(bid.Quantity, item.Quantity)
+				t.sendTradeResultNotify(item, bid, OrderSell, bid.Price, curTradeQty)
+				if curTradeQty.Equal(bid.Quantity) {
+					t.bidQueue.Remove(index)
 				} else {
-					curTradeQty = item.Quantity
-					t.bidQueue.Pq.SetQuantity(index, bid.Quantity.Sub(item.Quantity))
+					t.bidQueue.SetQuantity(index, bid.Quantity.Sub(curTradeQty))
 				}
 
-				t.sendTradeResultNotify(bid, item, bid.Price, curTradeQty)
 				item.Quantity = item.Quantity.Sub(curTradeQty)
 				return true
 			}
@@ -245,47 +718,11 @@ func (t *QueueTicker) Sell(item Order) {
 		}()
 
 		if !ok {
-			time.Sleep(time.Duration(200) * time.Millisecond)
 			break
 		}
 	}
 
-	if t.bidQueue.Pq.Len() != 0 && item.Quantity.Equal(decimal.Zero) {
-		defer t.askQueue.Remove(t.askQueue.GetIndexById(item.OrderId))
-	}
-}
-
-func (t *QueueTicker) depthTicker(que *OrderQueue) {
-
-	ticker := time.NewTicker(time.Duration(100) * time.Millisecond)
-
-	for {
-		<-ticker.C
-		func() {
-			que.Lock()
-			defer que.Unlock()
-			que.Depth = [][2]string{}
-			depthMap := make(map[string]string)
-
-			if que.Pq.Len() > 0 {
-
-				for i := 0; i < que.Pq.Len(); i++ {
-					item := (*que.Pq)[i]
-
-					price := FormatDecimal2String(item.Price, 2)
-					if _, ok := depthMap[price]; !ok {
-						depthMap[price] = FormatDecimal2String(item.Quantity, 4)
-					} else {
-						old_qunantity, _ := decimal.NewFromString(depthMap[price])
-						depthMap[price] = FormatDecimal2String(old_qunantity.Add(item.Quantity), 4)
-					}
-				}
-
-				//按价格排序map
-				que.Depth = sortMap2Slice(depthMap, que.Top().OrderType)
-			}
-		}()
-	}
+	t.askQueue.settle(item)
 }
 
 func FormatDecimal2String(d decimal.Decimal, digit int) string {
@@ -293,54 +730,3 @@ func FormatDecimal2String(d decimal.Decimal, digit int) string {
 	format := "%." + fmt.Sprintf("%d", digit) + "f"
 	return fmt.Sprintf(format, f)
 }
-
-func sortMap2Slice(m map[string]string, ask_bid OrderType) [][2]string {
-	res := [][2]string{}
-	keys := []string{}
-	for k, _ := range m {
-		keys = append(keys, k)
-	}
-
-	if ask_bid == OrderSell {
-		keys = quickSort(keys, "asc")
-	} else {
-		keys = quickSort(keys, "desc")
-	}
-
-	for _, k := range keys {
-		res = append(res, [2]string{k, m[k]})
-	}
-	return res
-}
-
-func quickSort(nums []string, asc_desc string) []string {
-	if len(nums) <= 1 {
-		return nums
-	}
-
-	spilt := nums[0]
-	left := []string{}
-	right := []string{}
-	mid := []string{}
-
-	for _, v := range nums {
-		vv, _ := decimal.NewFromString(v)
-		sp, _ := decimal.NewFromString(spilt)
-		if vv.Cmp(sp) == -1 {
-			left = append(left, v)
-		} else if vv.Cmp(sp) == 1 {
-			right = append(right, v)
-		} else {
-			mid = append(mid, v)
-		}
-	}
-
-	left = quickSort(left, asc_desc)
-	right = quickSort(right, asc_desc)
-
-	if asc_desc == "asc" {
-		return append(append(left, mid...), right...)
-	} else {
-		return append(append(right, mid...), left...)
-	}
-}
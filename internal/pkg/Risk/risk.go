@@ -0,0 +1,156 @@
+package Risk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	. "github.com/User/internal/pkg/Order"
+	"github.com/shopspring/decimal"
+)
+
+type Code string
+
+const (
+	CodeInvalidQuantity   Code = "INVALID_QUANTITY"
+	CodeInvalidPrice      Code = "INVALID_PRICE"
+	CodeMaxQuantity       Code = "MAX_QUANTITY_EXCEEDED"
+	CodeMaxNotional       Code = "MAX_NOTIONAL_EXCEEDED"
+	CodeMaxOpenOrders     Code = "MAX_OPEN_ORDERS_EXCEEDED"
+	CodeMaxPosition       Code = "MAX_POSITION_EXCEEDED"
+	CodePriceCollar       Code = "PRICE_OUTSIDE_COLLAR"
+	CodeNoLiquidity       Code = "NO_LIQUIDITY"
+	CodeInsufficientFunds Code = "INSUFFICIENT_FUNDS"
+	CodeUnknownOrder      Code = "UNKNOWN_ORDER"
+	CodeTradingHalted     Code = "TRADING_HALTED"
+	// CodeEngineError is an order the engine could not journal.
+	CodeEngineError Code = "ENGINE_ERROR"
+)
+
+// Rejection is the structured reason an order was refused before reaching the
+// matching engine.
+type Rejection struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%s: %s", r.Code, r.Message)
+}
+
+func Reject(code Code, format string, a ...interface{}) *Rejection {
+	return &Rejection{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Limits are the pre-trade limits of one symbol. A zero limit is not
+// enforced. PriceCollar is the largest allowed relative distance between a
+// limit price and the last trade price, e.g. 0.1 for 10%.
+type Limits struct {
+	MaxOrderQuantity decimal.Decimal `json:"max_order_quantity"`
+	MaxOrderNotional decimal.Decimal `json:"max_order_notional"`
+	MaxPrice         decimal.Decimal `json:"max_price"`
+	MaxOpenOrders    int             `json:"max_open_orders"`
+	MaxPosition      decimal.Decimal `json:"max_position"`
+	PriceCollar      decimal.Decimal `json:"price_collar"`
+}
+
+// DefaultLimits are the range checks the order handler always enforced.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxOrderQuantity: decimal.NewFromInt(100000000),
+		MaxPrice:         decimal.NewFromInt(100000000),
+	}
+}
+
+func (l Limits) Validate() error {
+	if l.MaxOrderQuantity.IsNegative() || l.MaxOrderNotional.IsNegative() || l.MaxPrice.IsNegative() ||
+		l.MaxPosition.IsNegative() || l.PriceCollar.IsNegative() || l.MaxOpenOrders < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// Context is the account and market state an order is checked against.
+// Position is the base the account holds plus the unfilled quantity of its
+// open bids, which it holds quote for.
+type Context struct {
+	LastPrice  decimal.Decimal
+	OpenOrders int
+	Position   decimal.Decimal
+}
+
+type Checker struct {
+	limits Limits
+
+	sync.Mutex
+}
+
+func NewChecker(limits Limits) *Checker {
+	return &Checker{limits: limits}
+}
+
+func (c *Checker) Limits() Limits {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.limits
+}
+
+func (c *Checker) SetLimits(limits Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.limits = limits
+	return nil
+}
+
+// Check runs every pre-trade check on an order and returns the first
+// violation, or nil if the order may be sent to the matching engine.
+func (c *Checker) Check(order Order, ctx Context) *Rejection {
+	l := c.Limits()
+
+	if !order.Quantity.IsPositive() {
+		return Reject(CodeInvalidQuantity, "quantity must be greater than 0")
+	}
+	if l.MaxOrderQuantity.IsPositive() && order.Quantity.Cmp(l.MaxOrderQuantity) > 0 {
+		return Reject(CodeMaxQuantity, "quantity %s exceeds %s", order.Quantity, l.MaxOrderQuantity)
+	}
+
+	price := order.Price
+	if order.PriceType == PriceLimit {
+		if !order.Price.IsPositive() {
+			return Reject(CodeInvalidPrice, "price must be greater than 0")
+		}
+		if l.MaxPrice.IsPositive() && order.Price.Cmp(l.MaxPrice) > 0 {
+			return Reject(CodeInvalidPrice, "price %s exceeds %s", order.Price, l.MaxPrice)
+		}
+		if l.PriceCollar.IsPositive() && ctx.LastPrice.IsPositive() {
+			distance := order.Price.Sub(ctx.LastPrice).Abs().Div(ctx.LastPrice)
+			if distance.Cmp(l.PriceCollar) > 0 {
+				return Reject(CodePriceCollar, "price %s is more than %s away from last price %s", order.Price, l.PriceCollar, ctx.LastPrice)
+			}
+		}
+	} else {
+		// market orders are valued at the last trade price
+		price = ctx.LastPrice
+	}
+
+	if l.MaxOrderNotional.IsPositive() {
+		if notional := order.Quantity.Mul(price); notional.Cmp(l.MaxOrderNotional) > 0 {
+			return Reject(CodeMaxNotional, "notional %s exceeds %s", notional, l.MaxOrderNotional)
+		}
+	}
+	if l.MaxOpenOrders > 0 && ctx.OpenOrders >= l.MaxOpenOrders {
+		return Reject(CodeMaxOpenOrders, "%d open orders reached", l.MaxOpenOrders)
+	}
+	if l.MaxPosition.IsPositive() && order.OrderType == OrderBuy {
+		if position := ctx.Position.Add(order.Quantity); position.Cmp(l.MaxPosition) > 0 {
+			return Reject(CodeMaxPosition, "position %s would exceed %s", position, l.MaxPosition)
+		}
+	}
+	return nil
+}
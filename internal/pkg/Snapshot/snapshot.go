@@ -0,0 +1,158 @@
+package Snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/User/internal/pkg/Account"
+	"github.com/User/internal/pkg/Ledger"
+	. "github.com/User/internal/pkg/Order"
+	"github.com/shopspring/decimal"
+)
+
+// Version is the snapshot format written by this package. Files of any other
+// version are refused.
+const Version = 2
+
+const extension = ".snap"
+
+// Snapshot is the full state of one QueueTicker at JournalSeq: restoring it
+// and replaying the journal after JournalSeq rebuilds the ticker. Asks and
+// Bids are the raw heap slices, so the restored queues are identical.
+// Accounts and Postings are the funds and ledger at the same point, so the
+// restored orders keep the holds they had. BookSeq and BBOSeq carry the L3 and
+// BBO sequence numbers on, so feeds continue where they left off.
+type Snapshot struct {
+	Symbol      string           `json:"symbol"`
+	JournalSeq  uint64           `json:"journal_seq"`
+	TradeSeq    uint64           `json:"trade_seq"`
+	BookSeq     uint64           `json:"book_seq"`
+	BBOSeq      uint64           `json:"bbo_seq"`
+	LatestPrice decimal.Decimal  `json:"latest_price"`
+	Status      string           `json:"status"`
+	Asks        []Order          `json:"asks"`
+	Bids        []Order          `json:"bids"`
+	Accounts    Account.State    `json:"accounts"`
+	Postings    []Ledger.Posting `json:"postings"`
+	CreateTime  int64            `json:"create_time"`
+}
+
+// file is the on-disk envelope. Checksum is the IEEE CRC32 of the raw
+// Snapshot JSON.
+type file struct {
+	Version  int             `json:"version"`
+	Checksum uint32          `json:"checksum"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// FileName is the name of the snapshot of symbol at journal sequence seq.
+// Names sort in sequence order.
+func FileName(symbol string, seq uint64) string {
+	return fmt.Sprintf("%s-%020d%s", symbol, seq, extension)
+}
+
+// Write stores a snapshot in dir and returns its path. The file is written
+// to a temporary name, synced and then renamed, so a crash never leaves a
+// partial snapshot behind.
+func Write(dir string, s Snapshot) (string, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(file{Version: Version, Checksum: crc32.ChecksumIEEE(body), Snapshot: body})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, FileName(s.Symbol, s.JournalSeq))
+	tmp, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// Read loads a snapshot file and verifies its version and checksum.
+func Read(path string) (Snapshot, error) {
+	var s Snapshot
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return s, fmt.Errorf("%s: %v", path, err)
+	}
+	if f.Version != Version {
+		return s, fmt.Errorf("%s: unsupported version %d", path, f.Version)
+	}
+	if crc32.ChecksumIEEE(f.Snapshot) != f.Checksum {
+		return s, fmt.Errorf("%s: checksum mismatch", path)
+	}
+	if err := json.Unmarshal(f.Snapshot, &s); err != nil {
+		return s, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// List returns the snapshot files of symbol in dir, newest first.
+func List(dir, symbol string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, symbol+"-*"+extension))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths, nil
+}
+
+// Latest loads the newest valid snapshot of symbol in dir. Invalid files are
+// skipped. ok is false when there is no valid snapshot.
+func Latest(dir, symbol string) (s Snapshot, path string, ok bool) {
+	paths, err := List(dir, symbol)
+	if err != nil {
+		return s, "", false
+	}
+	for _, path := range paths {
+		if s, err := Read(path); err == nil && s.Symbol == symbol {
+			return s, path, true
+		}
+	}
+	return s, "", false
+}
+
+// Prune removes all but the newest keep snapshots of symbol in dir.
+func Prune(dir, symbol string, keep int) error {
+	paths, err := List(dir, symbol)
+	if err != nil {
+		return err
+	}
+	for i, path := range paths {
+		if i >= keep {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package Ticker
+
+import (
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// Window is the length of the rolling statistics window in seconds.
+const Window = 24 * 60 * 60
+
+// Stats are the rolling statistics of one symbol over the last Window
+// seconds. Change is Last - Open and ChangePercent is relative to Open.
+// Times are unix seconds.
+type Stats struct {
+	Symbol           string          `json:"symbol"`
+	Open             decimal.Decimal `json:"open"`
+	High             decimal.Decimal `json:"high"`
+	Low              decimal.Decimal `json:"low"`
+	Last             decimal.Decimal `json:"last"`
+	Volume           decimal.Decimal `json:"volume"`
+	QuoteVolume      decimal.Decimal `json:"quote_volume"`
+	Change           decimal.Decimal `json:"change"`
+	ChangePercent    decimal.Decimal `json:"change_percent"`
+	WeightedAvgPrice decimal.Decimal `json:"weighted_avg_price"`
+	Trades           int             `json:"trades"`
+	BestBid          decimal.Decimal `json:"best_bid"`
+	BestBidQuantity  decimal.Decimal `json:"best_bid_quantity"`
+	BestAsk          decimal.Decimal `json:"best_ask"`
+	BestAskQuantity  decimal.Decimal `json:"best_ask_quantity"`
+	OpenTime         int64           `json:"open_time"`
+	CloseTime        int64           `json:"close_time"`
+}
+
+type entry struct {
+	seq      uint64
+	price    decimal.Decimal
+	quantity decimal.Decimal
+	amount   decimal.Decimal
+	time     int64
+}
+
+// Rolling keeps the statistics of one symbol up to date as trades arrive.
+// Every trade is added and evicted once; high and low are kept in monotonic
+// queues, so neither Add nor Stats scans the window.
+type Rolling struct {
+	symbol string
+	window int64
+	seq    uint64
+	last   decimal.Decimal
+
+	trades []entry
+	highs  []entry // prices decreasing from the front
+	lows   []entry // prices increasing from the front
+
+	volume      decimal.Decimal
+	quoteVolume decimal.Decimal
+
+	sync.Mutex
+}
+
+func NewRolling(symbol string) *Rolling {
+	return NewRollingWindow(symbol, Window)
+}
+
+// NewRollingWindow is NewRolling with a window of seconds other than 24h.
+func NewRollingWindow(symbol string, seconds int64) *Rolling {
+	return &Rolling{
+		symbol: symbol,
+		window: seconds,
+		trades: make([]entry, 0),
+		highs:  make([]entry, 0),
+		lows:   make([]entry, 0),
+	}
+}
+
+// Add applies a trade. Trades must be added in time order.
+func (r *Rolling) Add(price, quantity, amount decimal.Decimal, tradeTime int64) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.seq++
+	e := entry{seq: r.seq, price: price, quantity: quantity, amount: amount, time: tradeTime}
+	r.trades = append(r.trades, e)
+	r.volume = r.volume.Add(quantity)
+	r.quoteVolume = r.quoteVolume.Add(amount)
+	r.last = price
+
+	for len(r.highs) > 0 && r.highs[len(r.highs)-1].price.LessThanOrEqual(price) {
+		r.highs = r.highs[:len(r.highs)-1]
+	}
+	r.highs = append(r.highs, e)
+	for len(r.lows) > 0 && r.lows[len(r.lows)-1].price.GreaterThanOrEqual(price) {
+		r.lows = r.lows[:len(r.lows)-1]
+	}
+	r.lows = append(r.lows, e)
+
+	r.evict(tradeTime)
+}
+
+// evict drops the trades that are no longer inside the window ending at now.
+func (r *Rolling) evict(now int64) {
+	for len(r.trades) > 0 && r.trades[0].time <= now-r.window {
+		e := r.trades[0]
+		r.trades = r.trades[1:]
+		r.volume = r.volume.Sub(e.quantity)
+		r.quoteVolume = r.quoteVolume.Sub(e.amount)
+		if len(r.highs) > 0 && r.highs[0].seq == e.seq {
+			r.highs = r.highs[1:]
+		}
+		if len(r.lows) > 0 && r.lows[0].seq == e.seq {
+			r.lows = r.lows[1:]
+		}
+	}
+}
+
+// Stats returns the statistics of the window ending at now. Without trades in
+// the window every price is the last known price and volumes are zero. The
+// best bid and ask are left for the caller to fill in from the book.
+func (r *Rolling) Stats(now int64) Stats {
+	r.Lock()
+	defer r.Unlock()
+
+	r.evict(now)
+
+	s := Stats{
+		Symbol:           r.symbol,
+		Open:             r.last,
+		High:             r.last,
+		Low:              r.last,
+		Last:             r.last,
+		Volume:           r.volume,
+		QuoteVolume:      r.quoteVolume,
+		WeightedAvgPrice: r.last,
+		Trades:           len(r.trades),
+		OpenTime:         now - r.window + 1,
+		CloseTime:        now,
+	}
+	if len(r.trades) > 0 {
+		s.Open = r.trades[0].price
+		s.High = r.highs[0].price
+		s.Low = r.lows[0].price
+		if r.volume.IsPositive() {
+			s.WeightedAvgPrice = r.quoteVolume.DivRound(r.volume, 8)
+		}
+	}
+	s.Change = s.Last.Sub(s.Open)
+	if s.Open.IsPositive() {
+		s.ChangePercent = s.Change.Mul(decimal.NewFromInt(100)).DivRound(s.Open, 4)
+	}
+	return s
+}
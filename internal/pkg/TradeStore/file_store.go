@@ -0,0 +1,74 @@
+package TradeStore
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// FileStore persists trades to an append-only JSON lines file and answers
+// queries from an in-memory index loaded when the file is opened.
+type FileStore struct {
+	*MemoryStore
+	file *os.File
+}
+
+// OpenFileStore opens or creates a trade file. A partially written last
+// line, left by a crash, is dropped.
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		file:        file,
+	}
+
+	var end int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var t Trade
+		if err := json.Unmarshal(line, &t); err != nil {
+			break
+		}
+		s.trades = append(s.trades, t)
+		end += int64(len(line))
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(end, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) Append(trade Trade) (Trade, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	trade.Seq = s.lastSeq() + 1
+	line, err := json.Marshal(trade)
+	if err != nil {
+		return trade, err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return trade, err
+	}
+	return s.append(trade), nil
+}
+
+func (s *FileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,177 @@
+package TradeStore
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// Trade is one stored trade. Seq is assigned by the store and orders the
+// trades of every symbol.
+type Trade struct {
+	Seq          uint64          `json:"seq"`
+	TradeId      string          `json:"trade_id"`
+	Symbol       string          `json:"symbol"`
+	AskOrderId   string          `json:"ask_order_id"`
+	BidOrderId   string          `json:"bid_order_id"`
+	AskAccountId string          `json:"ask_account_id,omitempty"`
+	BidAccountId string          `json:"bid_account_id,omitempty"`
+	Price        decimal.Decimal `json:"price"`
+	Quantity     decimal.Decimal `json:"quantity"`
+	Amount       decimal.Decimal `json:"amount"`
+	Time         int64           `json:"time"`
+}
+
+// Query selects trades, newest first. Zero values do not filter. From and To
+// are unix seconds, inclusive. Cursor is the NextCursor of a previous page.
+type Query struct {
+	Symbol    string
+	From      int64
+	To        int64
+	OrderId   string
+	AccountId string
+	Limit     int
+	Cursor    string
+}
+
+// Page is one page of a query. NextCursor is empty on the last page.
+type Page struct {
+	Trades     []Trade `json:"trades"`
+	NextCursor string  `json:"next_cursor"`
+}
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type Store interface {
+	Append(trade Trade) (Trade, error)
+	Query(q Query) (Page, error)
+	Recent(symbol string, n int) []Trade
+}
+
+func (q Query) match(t Trade) bool {
+	if q.Symbol != "" && t.Symbol != q.Symbol {
+		return false
+	}
+	if (q.From > 0 && t.Time < q.From) || (q.To > 0 && t.Time > q.To) {
+		return false
+	}
+	if q.OrderId != "" && t.AskOrderId != q.OrderId && t.BidOrderId != q.OrderId {
+		return false
+	}
+	if q.AccountId != "" && t.AskAccountId != q.AccountId && t.BidAccountId != q.AccountId {
+		return false
+	}
+	return true
+}
+
+func encodeCursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(seq, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	seq, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return seq, nil
+}
+
+// MemoryStore keeps trades in memory only. It is meant for tests and is the
+// index behind FileStore.
+type MemoryStore struct {
+	trades []Trade
+
+	sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		trades: make([]Trade, 0),
+	}
+}
+
+func (m *MemoryStore) Append(trade Trade) (Trade, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.append(trade), nil
+}
+
+func (m *MemoryStore) append(trade Trade) Trade {
+	trade.Seq = m.lastSeq() + 1
+	m.trades = append(m.trades, trade)
+	return trade
+}
+
+func (m *MemoryStore) lastSeq() uint64 {
+	if len(m.trades) == 0 {
+		return 0
+	}
+	return m.trades[len(m.trades)-1].Seq
+}
+
+func (m *MemoryStore) Query(q Query) (Page, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	var before uint64
+	if q.Cursor != "" {
+		seq, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		before = seq
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	page := Page{Trades: []Trade{}}
+	for i := len(m.trades) - 1; i >= 0; i-- {
+		t := m.trades[i]
+		if before > 0 && t.Seq >= before {
+			continue
+		}
+		if !q.match(t) {
+			continue
+		}
+		if len(page.Trades) == q.Limit {
+			page.NextCursor = encodeCursor(page.Trades[len(page.Trades)-1].Seq)
+			break
+		}
+		page.Trades = append(page.Trades, t)
+	}
+	return page, nil
+}
+
+// Recent returns the last n trades of a symbol, oldest first.
+func (m *MemoryStore) Recent(symbol string, n int) []Trade {
+	m.RLock()
+	defer m.RUnlock()
+
+	res := []Trade{}
+	for i := len(m.trades) - 1; i >= 0 && len(res) < n; i-- {
+		if m.trades[i].Symbol == symbol {
+			res = append(res, m.trades[i])
+		}
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
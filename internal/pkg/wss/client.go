@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"log"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 
 	"github.com/gorilla/websocket"
 )
@@ -22,7 +25,7 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	maxMessageSize = 4096
 )
 
 var (
@@ -31,8 +34,10 @@ var (
 )
 
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	Subprotocols:      []string{"json", "msgpack", "binary"},
+	EnableCompression: true,
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
@@ -42,24 +47,61 @@ var upgrader = websocket.Upgrader{
 type Client struct {
 	hub *Hub
 
-	// The websocket connection.
+	// id tells the client's connection apart from the others.
+	id string
+
+	// The shard of the hub the client belongs to.
+	shard *shard
+
+	// The websocket connection; nil for the HTTP transports, see http.go.
 	conn *websocket.Conn
 
-	lastMsgHash map[string]string
+	// The address of the peer.
+	remote string
+
+	// How messages are encoded for the client.
+	encoding Encoding
+
+	// Topics the client subscribed to. Only the shard goroutine touches
+	// them.
+	topics map[string]bool
+
+	// The account the client logged in as, if any. Set by readPump and
+	// read by the hub, hence the mutex.
+	accountId string
+	mu        sync.Mutex
 
-	// Buffered channel of outbound messages.
-	send chan []byte
+	// Outbound messages, see outbox.go.
+	send *outbox
+}
+
+// stats returns the client's metrics. Only the shard goroutine calls it.
+func (c *Client) stats() ClientStats {
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return ClientStats{
+		Remote:     c.remote,
+		Account:    c.account(),
+		Topics:     topics,
+		QueueStats: c.send.snapshot(),
+	}
 }
 
-// readPump pumps messages from the websocket connection to the hub.
+// readPump pumps requests from the websocket connection to the hub.
 //
 // The application runs readPump in a per-connection goroutine. The application
 // ensures that there is at most one reader on a connection by executing all
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.shard.unregister <- c
 		c.conn.Close()
+		if accountId := c.account(); accountId != "" && c.hub.onDisconnect != nil {
+			c.hub.onDisconnect(Session{Id: c.id, AccountId: accountId})
+		}
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -73,7 +115,7 @@ func (c *Client) readPump() {
 			break
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		c.hub.broadcast <- message
+		c.serve(message)
 	}
 }
 
@@ -90,25 +132,40 @@ func (c *Client) writePump() {
 	}()
 	for {
 		select {
-		case message, ok := <-c.send:
+		case <-c.send.notify:
+			messages, closed, reason := c.send.take()
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			if closed {
+				// The hub dropped the client.
+				payload := []byte{}
+				if reason != "" {
+					payload = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, payload)
 				return
 			}
+			if len(messages) == 0 {
+				continue
+			}
+			if c.encoding != EncodingJSON {
+				for _, message := range messages {
+					if err := c.conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+						return
+					}
+				}
+				continue
+			}
 
+			// Everything queued goes out in one websocket message.
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
 			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
+			for i, message := range messages {
+				if i > 0 {
+					w.Write(newline)
+				}
+				w.Write(message)
 			}
 
 			if err := w.Close(); err != nil {
@@ -123,20 +180,42 @@ func (c *Client) writePump() {
 	}
 }
 
-// serveWs handles websocket requests from the peer.
+// serveWs handles websocket requests from the peer. The encoding is the
+// subprotocol the peer asked for, or else the encoding query parameter;
+// messages are compressed if the peer supports permessage-deflate.
 func ServeWs(c *gin.Context) {
+	encoding := EncodingJSON
+	if name := c.Query("encoding"); name != "" {
+		var ok bool
+		if encoding, ok = ParseEncoding(name); !ok {
+			c.JSON(200, gin.H{
+				"ok":    false,
+				"error": "unknown encoding " + name,
+			})
+			return
+		}
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	if name := conn.Subprotocol(); name != "" {
+		encoding, _ = ParseEncoding(name)
+	}
+	conn.EnableWriteCompression(true)
+
 	client := &Client{
-		hub:         HHub,
-		conn:        conn,
-		send:        make(chan []byte, 256),
-		lastMsgHash: make(map[string]string),
+		hub:      HHub,
+		id:       uuid.NewString(),
+		conn:     conn,
+		remote:   conn.RemoteAddr().String(),
+		encoding: encoding,
+		send:     newOutbox(),
+		topics:   make(map[string]bool),
 	}
-	client.hub.register <- client
+	client.hub.register(client)
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
@@ -0,0 +1,87 @@
+package wss
+
+import (
+	"log"
+	"sync"
+)
+
+// Encoding is how messages are encoded for a client, chosen when it
+// connects: by websocket subprotocol, or by the encoding query parameter.
+//
+// JSON messages go out as text frames, several to a frame separated by
+// newlines. The others go out as binary frames, one message each:
+// MessagePack is the JSON message as MessagePack, and Binary is the compact
+// layout of the message's channel, see SetBinary, or MessagePack for
+// channels without one. Requests are JSON whatever the encoding.
+type Encoding int
+
+const (
+	EncodingJSON Encoding = iota
+	EncodingMsgpack
+	EncodingBinary
+)
+
+var encodings = map[string]Encoding{
+	"json":    EncodingJSON,
+	"msgpack": EncodingMsgpack,
+	"binary":  EncodingBinary,
+}
+
+// ParseEncoding returns the encoding of a name: json, msgpack or binary.
+func ParseEncoding(name string) (Encoding, bool) {
+	e, ok := encodings[name]
+	return e, ok
+}
+
+// BinaryEncoder encodes a message of a channel in a compact layout. data
+// is the message's data as JSON. A layout starts with a byte that tells it
+// apart from the others and from MessagePack maps, so below 0x80.
+type BinaryEncoder func(topic string, seq uint64, data []byte) ([]byte, error)
+
+// SetBinary sets the compact layout of the messages of a channel for
+// clients with EncodingBinary. It must be called before Run.
+func (h *Hub) SetBinary(channel string, encode BinaryEncoder) {
+	h.binary[channel] = encode
+}
+
+// payload is a message in every encoding, each encoded once, when a client
+// first needs it.
+type payload struct {
+	json []byte
+
+	// binary is the compact layout of the message, if its channel has one.
+	binary func() ([]byte, error)
+
+	once    [2]sync.Once
+	encoded [2][]byte
+}
+
+func newPayload(message []byte) *payload {
+	return &payload{json: message}
+}
+
+func (p *payload) encode(e Encoding) []byte {
+	switch e {
+	case EncodingMsgpack:
+		p.once[0].Do(func() {
+			var err error
+			if p.encoded[0], err = jsonToMsgpack(p.json); err != nil {
+				log.Printf("wss: msgpack: %v", err)
+			}
+		})
+		return p.encoded[0]
+	case EncodingBinary:
+		if p.binary == nil {
+			return p.encode(EncodingMsgpack)
+		}
+		p.once[1].Do(func() {
+			var err error
+			if p.encoded[1], err = p.binary(); err != nil {
+				log.Printf("wss: binary: %v", err)
+				p.encoded[1] = p.encode(EncodingMsgpack)
+			}
+		})
+		return p.encoded[1]
+	}
+	return p.json
+}
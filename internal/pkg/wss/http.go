@@ -0,0 +1,245 @@
+package wss
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Clients that cannot use websockets get the same messages over HTTP, from
+// the same hub: as server-sent events from ServeSSE, or by long polling
+// ServePoll. Both take the topics to subscribe to and a cursor:
+//
+//	GET /sse?topics=trade:AA,depth:AA
+//	GET /poll?topics=trade:AA,depth:AA&cursor=depth:AA=7,trade:AA=41
+//
+// The cursor is the last seq got on each topic. Topics in the cursor are
+// resumed, the others subscribed to, as a websocket client would, so the
+// messages, replies included, are those a websocket client gets. The cursor
+// after each message is the id of its event, which EventSource sends back
+// as Last-Event-ID when it reconnects; a poll returns it with the messages.
+// Private channels need the login, in headers rather than in the URL, where
+// it would be logged: X-Token, or X-Account-Id, X-Timestamp and
+// X-Signature. EventSource cannot send headers, so private SSE streams take
+// a client that can.
+
+const (
+	// pollTimeout is how long a poll waits for messages.
+	pollTimeout = 25 * time.Second
+)
+
+// cursor is the last seq got on each topic, by the name it is subscribed
+// to.
+type cursor map[string]uint64
+
+func parseCursor(s string) cursor {
+	c := cursor{}
+	for _, part := range strings.Split(s, ",") {
+		i := strings.LastIndex(part, "=")
+		if i < 0 {
+			continue
+		}
+		if seq, err := strconv.ParseUint(part[i+1:], 10, 64); err == nil {
+			c[part[:i]] = seq
+		}
+	}
+	return c
+}
+
+func (c cursor) String() string {
+	parts := make([]string, 0, len(c))
+	for topic, seq := range c {
+		parts = append(parts, fmt.Sprintf("%s=%d", topic, seq))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// update moves the cursor past a message, and reports whether the message
+// is more than a reply to a subscription.
+func (c cursor) update(message []byte) bool {
+	var m struct {
+		Tag    string            `json:"tag"`
+		Topic  string            `json:"topic"`
+		Seq    uint64            `json:"seq"`
+		Event  string            `json:"event"`
+		Topics []string          `json:"topics"`
+		Seqs   map[string]uint64 `json:"seqs"`
+	}
+	json.Unmarshal(message, &m)
+
+	switch {
+	case m.Tag != "":
+		// private topics are subscribed to by their channel
+		topic := m.Topic
+		if i := strings.Index(topic, "#"); i >= 0 {
+			topic = topic[:i]
+		}
+		c[topic] = m.Seq
+		return true
+	case m.Event == EventSnapshotRequired:
+		// until a snapshot comes, the client has to get one of its own
+		for _, topic := range m.Topics {
+			c[topic] = m.Seq
+		}
+		return true
+	case m.Event == EventSubscribed:
+		// a topic that sends nothing before the next poll resumes from here
+		for topic, seq := range m.Seqs {
+			c[topic] = seq
+		}
+	}
+	return m.Event == EventError
+}
+
+// httpClient is a client of the hub served over HTTP, subscribed to topics
+// from cursor.
+func httpClient(ctx *gin.Context, topics []string, from cursor) *Client {
+	client := &Client{
+		hub:    HHub,
+		id:     uuid.NewString(),
+		remote: ctx.Request.RemoteAddr,
+		send:   newOutbox(),
+		topics: make(map[string]bool),
+	}
+	HHub.register(client)
+
+	login := Login{
+		Token:     ctx.GetHeader("X-Token"),
+		AccountId: ctx.GetHeader("X-Account-Id"),
+		Signature: ctx.GetHeader("X-Signature"),
+	}
+	login.Timestamp, _ = strconv.ParseInt(ctx.GetHeader("X-Timestamp"), 10, 64)
+	if login.Token != "" || login.AccountId != "" {
+		client.request(request{Op: OpLogin, Login: login})
+	}
+	for _, topic := range topics {
+		if seq, ok := from[topic]; ok {
+			client.request(request{Op: OpResume, Topic: topic, Seq: seq})
+		} else {
+			client.request(request{Op: OpSubscribe, Topics: []string{topic}})
+		}
+	}
+	return client
+}
+
+// request serves a request as if the client had sent it.
+func (c *Client) request(req request) {
+	message, _ := json.Marshal(req)
+	c.serve(message)
+}
+
+func (c *Client) unregister() {
+	c.shard.unregister <- c
+}
+
+// topicsParam is the topics query parameter, comma separated. Without
+// topics it replies with an error and returns nil.
+func topicsParam(ctx *gin.Context) []string {
+	topics := []string{}
+	for _, topic := range strings.Split(ctx.Query("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		ctx.JSON(200, gin.H{
+			"ok":    false,
+			"error": "no topics given",
+		})
+		return nil
+	}
+	return topics
+}
+
+// ServeSSE streams the messages of topics as server-sent events.
+func ServeSSE(ctx *gin.Context) {
+	last := ctx.GetHeader("Last-Event-ID")
+	if last == "" {
+		last = ctx.Query("cursor")
+	}
+	topics := topicsParam(ctx)
+	if topics == nil {
+		return
+	}
+	position := parseCursor(last)
+	client := httpClient(ctx, topics, position)
+	defer client.unregister()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Status(200)
+	ctx.Writer.Flush()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-client.send.notify:
+			messages, closed, reason := client.send.take()
+			if closed {
+				fmt.Fprintf(ctx.Writer, "event: close\ndata: %s\n\n", reason)
+				ctx.Writer.Flush()
+				return
+			}
+			for _, message := range messages {
+				position.update(message)
+				fmt.Fprintf(ctx.Writer, "id: %s\ndata: %s\n\n", position, message)
+			}
+			ctx.Writer.Flush()
+		case <-ticker.C:
+			fmt.Fprint(ctx.Writer, ": ping\n\n")
+			ctx.Writer.Flush()
+		}
+	}
+}
+
+// ServePoll returns the messages of topics after the cursor, waiting up to
+// pollTimeout for some.
+func ServePoll(ctx *gin.Context) {
+	topics := topicsParam(ctx)
+	if topics == nil {
+		return
+	}
+	position := parseCursor(ctx.Query("cursor"))
+	client := httpClient(ctx, topics, position)
+	defer client.unregister()
+
+	messages := []json.RawMessage{}
+	timeout := time.NewTimer(pollTimeout)
+	defer timeout.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-timeout.C:
+			done = true
+		case <-client.send.notify:
+			taken, closed, _ := client.send.take()
+			for _, message := range taken {
+				messages = append(messages, message)
+				if position.update(message) {
+					done = true
+				}
+			}
+			done = done || closed
+		}
+	}
+
+	ctx.JSON(200, gin.H{
+		"ok": true,
+		"data": gin.H{
+			"messages": messages,
+			"cursor":   position.String(),
+		},
+	})
+}
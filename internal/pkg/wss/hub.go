@@ -1,83 +1,283 @@
 package wss
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"encoding/json"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to the
-// clients.
+// Hub delivers each published message to the clients subscribed to its
+// topic. The hub goroutine only numbers and encodes a message, once; the
+// clients are spread over shards, each delivering to its own clients in its
+// own goroutine.
+//
+// Every topic is numbered on its own: its messages carry seq 1, 2, 3...
+// Subscriptions also go through the hub goroutine, so that a client
+// subscribing to a topic with a snapshot, see SetSnapshot, first gets the
+// snapshot as of some seq and then every message after it.
 type Hub struct {
-	// Registered clients.
-	clients map[*Client]bool
+	shards []*shard
 
-	// Inbound messages from the clients.
-	broadcast chan []byte
+	// next picks the shard of the next client, round robin.
+	next uint32
 
-	// Register requests from the clients.
-	register chan *Client
+	// Messages published to the clients.
+	publish chan Message
 
-	// Unregister requests from clients.
-	unregister chan *Client
+	// Subscription changes read from the clients, see protocol.go.
+	commands chan command
+
+	// The state of each topic. Only the hub goroutine touches it.
+	topics map[string]*topicState
+
+	// How many of the last messages of a channel make up the snapshot
+	// of its topics.
+	snapshots map[string]int
+
+	// validTopic reports whether clients may subscribe to a public topic.
+	validTopic func(topic string) bool
+
+	// privateChannels are the channels a logged in client subscribes to for
+	// its own account, see PrivateTopic.
+	privateChannels map[string]bool
+
+	// authenticate checks a login and returns its account.
+	authenticate func(login Login) (string, error)
+
+	// handlers serve the ops other than those of the protocol itself.
+	handlers map[string]Handler
+
+	// onDisconnect is told of the end of logged in connections.
+	onDisconnect func(session Session)
+
+	// binary are the compact layouts of channels, see SetBinary.
+	binary map[string]BinaryEncoder
+
+	// classes says how the messages of each channel are treated when a
+	// client falls behind; channels not listed are Critical.
+	classes map[string]Class
+}
+
+// Message is a message published on a topic. A non-zero Version numbers
+// the state the message carries, such as the book sequence of a depth
+// message: a message whose Version is not above that of the last message on
+// its topic is a repeat and is not sent. Messages without Version are
+// always sent.
+type Message struct {
+	Topic   string
+	Tag     string
+	Version uint64
+	Data    interface{}
 }
 
+// msgBody is a published message as clients receive it.
 type msgBody struct {
-	Tag  string      `json:"tag"`
-	Data interface{} `json:"data"`
+	Tag   string      `json:"tag"`
+	Topic string      `json:"topic"`
+	Seq   uint64      `json:"seq"`
+	Data  interface{} `json:"data"`
+}
+
+// TagSnapshot is the tag of snapshot messages. Their data is the data of
+// the last messages of the topic, oldest first, and their seq that of the
+// last one.
+const TagSnapshot = "snapshot"
+
+// replaySize is how many of the last messages of a topic are kept for
+// clients resuming it.
+const replaySize = 512
+
+// topicState is what the hub knows of one topic.
+type topicState struct {
+	seq     uint64
+	version uint64
+
+	// The data of the last messages, for the snapshot.
+	history []json.RawMessage
+
+	// The last messages, up to seq, for resuming.
+	replay []*payload
+}
+
+// oldest is the seq of the oldest message kept for resuming.
+func (t *topicState) oldest() uint64 {
+	return t.seq - uint64(len(t.replay)) + 1
+}
+
+// ClientStats are the metrics of one connected client.
+type ClientStats struct {
+	Remote  string   `json:"remote"`
+	Account string   `json:"account,omitempty"`
+	Topics  []string `json:"topics"`
+	QueueStats
+}
+
+// NewHub creates a hub whose clients may subscribe to the public topics
+// accepted by validTopic and, once logged in, to privateChannels.
+func NewHub(validTopic func(topic string) bool, privateChannels ...string) *Hub {
+	h := &Hub{
+		publish:         make(chan Message, 1024),
+		commands:        make(chan command),
+		topics:          make(map[string]*topicState),
+		snapshots:       make(map[string]int),
+		validTopic:      validTopic,
+		privateChannels: make(map[string]bool),
+		handlers:        make(map[string]Handler),
+		classes:         make(map[string]Class),
+		binary:          make(map[string]BinaryEncoder),
+	}
+	for _, channel := range privateChannels {
+		h.privateChannels[channel] = true
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		h.shards = append(h.shards, newShard(h))
+	}
+	return h
+}
+
+// SetAuthenticator sets how logins are checked; without one every login
+// fails. It must be called before Run.
+func (h *Hub) SetAuthenticator(authenticate func(login Login) (string, error)) {
+	h.authenticate = authenticate
+}
+
+// SetClass sets the class of the messages of channels, see Class. It must
+// be called before Run.
+func (h *Hub) SetClass(class Class, channels ...string) {
+	for _, channel := range channels {
+		h.classes[channel] = class
+	}
+}
+
+// SetSnapshot makes a client subscribing to a topic of channels first get
+// a snapshot of the last keep messages of the topic. It must be called
+// before Run.
+func (h *Hub) SetSnapshot(keep int, channels ...string) {
+	for _, channel := range channels {
+		h.snapshots[channel] = keep
+	}
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+// channel is the channel of a public topic. A private topic belongs to no
+// channel, so it never takes the class, snapshot or binary layout set for a
+// public channel of the same name: its messages are Critical.
+func channel(topic string) string {
+	if strings.Contains(topic, "#") {
+		return ""
 	}
+	if i := strings.IndexByte(topic, ':'); i >= 0 {
+		return topic[:i]
+	}
+	return topic
+}
+
+// class is the class of the messages of a topic, which is that of its
+// channel.
+func (h *Hub) class(topic string) Class {
+	return h.classes[channel(topic)]
 }
 
-func (h *Hub) Send(msg []byte) {
-	h.broadcast <- msg
+// Stats returns the metrics of every connected client.
+func (h *Hub) Stats() []ClientStats {
+	stats := []ClientStats{}
+	for _, s := range h.shards {
+		res := make(chan []ClientStats)
+		s.stats <- res
+		stats = append(stats, <-res...)
+	}
+	return stats
+}
+
+// PrivateTopic is the topic messages of a private channel are published on
+// for one account. Clients subscribe to the bare channel name.
+func PrivateTopic(channel, accountId string) string {
+	return channel + "#" + accountId
+}
+
+// Publish sends a message to the subscribers of its topic.
+func (h *Hub) Publish(m Message) {
+	h.publish <- m
+}
+
+// register hands a new client to a shard.
+func (h *Hub) register(client *Client) {
+	client.shard = h.shards[atomic.AddUint32(&h.next, 1)%uint32(len(h.shards))]
+	client.shard.register <- client
 }
 
 func (h *Hub) Run() {
+	for _, s := range h.shards {
+		go s.run()
+	}
 	for {
 		select {
-		case client := <-h.register:
-			h.clients[client] = true
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-		case message := <-h.broadcast:
-			var body msgBody
-			err := json.Unmarshal(message, &body)
-			if err == nil {
-				msgHash := md5String(message)
-				for client := range h.clients {
-
-					if _, ok := client.lastMsgHash[body.Tag]; ok {
-						if client.lastMsgHash[body.Tag] == msgHash {
-							continue
-						}
-					}
-					client.lastMsgHash[body.Tag] = msgHash
-
-					select {
-					case client.send <- message:
-					default:
-						close(client.send)
-						delete(h.clients, client)
-					}
-				}
-			}
+		case m := <-h.publish:
+			h.send(m)
+		case cmd := <-h.commands:
+			// the shard changes the subscriptions in order with the
+			// messages sent so far, after which come the reply and the
+			// snapshots or missed messages
+			h.subscription(&cmd)
+			cmd.client.shard.broadcast <- frame{cmd: &cmd}
 		}
 	}
 }
 
-func md5String(str []byte) string {
-	hasher := md5.New()
-	hasher.Write(str)
-	return hex.EncodeToString(hasher.Sum(nil))
+// send numbers, encodes and hands a message to the shards.
+func (h *Hub) send(m Message) {
+	state, ok := h.topics[m.Topic]
+	if !ok {
+		state = &topicState{}
+		h.topics[m.Topic] = state
+	}
+	if m.Version != 0 {
+		if m.Version <= state.version {
+			return
+		}
+		state.version = m.Version
+	}
+
+	data, err := json.Marshal(m.Data)
+	if err != nil {
+		log.Printf("wss: encoding %s: %v", m.Topic, err)
+		return
+	}
+	state.seq++
+	message, _ := json.Marshal(msgBody{Tag: m.Tag, Topic: m.Topic, Seq: state.seq, Data: json.RawMessage(data)})
+	p := newPayload(message)
+	if encode := h.binary[channel(m.Topic)]; encode != nil {
+		topic, seq := m.Topic, state.seq
+		p.binary = func() ([]byte, error) { return encode(topic, seq, data) }
+	}
+	state.replay = append(state.replay, p)
+	if len(state.replay) > replaySize {
+		state.replay = state.replay[len(state.replay)-replaySize:]
+	}
+	if keep := h.snapshots[channel(m.Topic)]; keep > 0 {
+		state.history = append(state.history, data)
+		if len(state.history) > keep {
+			state.history = state.history[len(state.history)-keep:]
+		}
+	}
+
+	f := frame{topic: m.Topic, class: h.class(m.Topic), payload: p}
+	for _, s := range h.shards {
+		s.broadcast <- f
+	}
+}
+
+// snapshot encodes the snapshots of those of topics that have one.
+func (h *Hub) snapshot(topics ...string) []frame {
+	snapshots := []frame{}
+	for _, topic := range topics {
+		state, ok := h.topics[topic]
+		if !ok || len(state.history) == 0 {
+			continue
+		}
+		message, _ := json.Marshal(msgBody{Tag: TagSnapshot, Topic: topic, Seq: state.seq, Data: state.history})
+		snapshots = append(snapshots, frame{payload: newPayload(message), class: Critical})
+	}
+	return snapshots
 }
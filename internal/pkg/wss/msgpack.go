@@ -0,0 +1,103 @@
+package wss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// jsonToMsgpack transcodes a JSON value to MessagePack. Numbers are
+// integers when they have no fraction and fit, floats otherwise; decimals
+// sent as strings stay strings. Map keys are sorted, so a value always has
+// the same encoding.
+func jsonToMsgpack(message []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(message))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	writeMsgpack(&b, v)
+	return b.Bytes(), nil
+}
+
+func writeMsgpack(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if v {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			writeMsgpackInt(b, i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			b.WriteByte(0xcf)
+			binary.Write(b, binary.BigEndian, u)
+		} else {
+			f, _ := v.Float64()
+			b.WriteByte(0xcb)
+			binary.Write(b, binary.BigEndian, math.Float64bits(f))
+		}
+	case string:
+		writeMsgpackHeader(b, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		b.WriteString(v)
+	case []interface{}:
+		writeMsgpackHeader(b, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			writeMsgpack(b, e)
+		}
+	case map[string]interface{}:
+		writeMsgpackHeader(b, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeMsgpack(b, k)
+			writeMsgpack(b, v[k])
+		}
+	}
+}
+
+// writeMsgpackHeader writes the type and length of a string, array or map:
+// fix below fixLimit, then the 8, 16 and 32 bit forms; a zero form is
+// skipped.
+func writeMsgpackHeader(b *bytes.Buffer, n int, fix byte, fixLimit int, t8, t16, t32 byte) {
+	switch {
+	case n < fixLimit:
+		b.WriteByte(fix | byte(n))
+	case t8 != 0 && n <= math.MaxUint8:
+		b.WriteByte(t8)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(t16)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(t32)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackInt(b *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		b.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		b.WriteByte(byte(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b.WriteByte(0xd2)
+		binary.Write(b, binary.BigEndian, int32(i))
+	default:
+		b.WriteByte(0xd3)
+		binary.Write(b, binary.BigEndian, i)
+	}
+}
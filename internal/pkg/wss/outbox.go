@@ -0,0 +1,186 @@
+package wss
+
+import (
+	"sync"
+)
+
+// Class decides what happens to a topic's messages when a client falls
+// behind.
+type Class int
+
+const (
+	// Critical messages are always delivered, in order. A client that lets
+	// too many of them pile up is disconnected.
+	Critical Class = iota
+	// Droppable messages are dropped, oldest first, when the client's
+	// queue backs up.
+	Droppable
+	// Conflated messages replace the pending message of their topic, so a
+	// slow client only gets the latest one.
+	Conflated
+)
+
+const (
+	// Above dropAbove queued messages, droppable messages are dropped.
+	dropAbove = 256
+
+	// A client with maxQueued messages queued even after dropping is
+	// disconnected.
+	maxQueued = 1024
+
+	// CloseSlowConsumer is the close reason sent to a client disconnected
+	// for not keeping up.
+	CloseSlowConsumer = "slow consumer"
+)
+
+type entry struct {
+	message []byte
+	class   Class
+}
+
+// outbox is the queue of messages waiting to be written to one client.
+// Pushing never blocks, so the hub is never held up by a slow client.
+type outbox struct {
+	queue     []entry
+	conflated map[string][]byte
+	topics    []string // conflated topics with a pending message, oldest first
+
+	// notify is signalled when there is something to write or the outbox
+	// was closed.
+	notify chan struct{}
+	closed bool
+	reason string
+
+	stats QueueStats
+
+	sync.Mutex
+}
+
+// QueueStats are the queue metrics of one client.
+type QueueStats struct {
+	Queued    int    `json:"queued"`
+	MaxQueued int    `json:"max_queued"`
+	Sent      uint64 `json:"sent"`
+	Dropped   uint64 `json:"dropped"`
+	Conflated uint64 `json:"conflated"`
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		queue:     make([]entry, 0),
+		conflated: make(map[string][]byte),
+		topics:    make([]string, 0),
+		notify:    make(chan struct{}, 1),
+	}
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) len() int {
+	return len(o.queue) + len(o.topics)
+}
+
+// push queues a message and reports false when the client has to be
+// disconnected for falling too far behind.
+func (o *outbox) push(topic string, message []byte, class Class) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.closed {
+		return true
+	}
+	switch class {
+	case Conflated:
+		if _, ok := o.conflated[topic]; ok {
+			o.stats.Conflated++
+		} else {
+			o.topics = append(o.topics, topic)
+		}
+		o.conflated[topic] = message
+	case Droppable:
+		if o.len() >= dropAbove {
+			o.stats.Dropped++
+			return true
+		}
+		o.queue = append(o.queue, entry{message, class})
+	default:
+		if o.len() >= maxQueued {
+			o.shed()
+		}
+		if o.len() >= maxQueued {
+			return false
+		}
+		o.queue = append(o.queue, entry{message, class})
+	}
+
+	if n := o.len(); n > o.stats.MaxQueued {
+		o.stats.MaxQueued = n
+	}
+	o.signal()
+	return true
+}
+
+// shed drops every queued droppable message.
+func (o *outbox) shed() {
+	kept := o.queue[:0]
+	for _, e := range o.queue {
+		if e.class == Droppable {
+			o.stats.Dropped++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	o.queue = kept
+}
+
+// take returns every queued message, conflated ones last. closed is set
+// once the outbox is closed; reason is then the close reason, if any.
+func (o *outbox) take() (messages [][]byte, closed bool, reason string) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.closed {
+		return nil, true, o.reason
+	}
+	messages = make([][]byte, 0, o.len())
+	for _, e := range o.queue {
+		messages = append(messages, e.message)
+	}
+	for _, topic := range o.topics {
+		messages = append(messages, o.conflated[topic])
+		delete(o.conflated, topic)
+	}
+	o.queue = o.queue[:0]
+	o.topics = o.topics[:0]
+	o.stats.Sent += uint64(len(messages))
+	return messages, false, ""
+}
+
+// close discards what is queued and makes the writer close the connection
+// with reason.
+func (o *outbox) close(reason string) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.closed {
+		return
+	}
+	o.closed = true
+	o.reason = reason
+	o.queue = nil
+	o.signal()
+}
+
+func (o *outbox) snapshot() QueueStats {
+	o.Lock()
+	defer o.Unlock()
+
+	s := o.stats
+	s.Queued = o.len()
+	return s
+}
@@ -0,0 +1,321 @@
+package wss
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Clients talk to the hub with JSON requests:
+//
+//	{"op": "subscribe", "topics": ["depth:AA", "kline:AA:1m"], "id": "1"}
+//	{"op": "unsubscribe", "topics": ["depth:AA"], "id": "2"}
+//	{"op": "resume", "topic": "trade:AA", "seq": 41, "id": "5"}
+//	{"op": "ping", "id": "3"}
+//	{"op": "login", "token": "...", "id": "4"}
+//	{"op": "login", "account": "demo", "timestamp": 1700000000000, "signature": "...", "id": "4"}
+//
+// and receive replies with the same id:
+//
+//	{"event": "subscribed", "topics": ["depth:AA", "kline:AA:1m"], "seqs": {"depth:AA": 7, "kline:AA:1m": 0}, "id": "1"}
+//	{"event": "unsubscribed", "topics": ["depth:AA"], "id": "2"}
+//	{"event": "pong", "time": 1700000000000, "id": "3"}
+//	{"event": "login", "account": "demo", "session": "...", "id": "4"}
+//	{"event": "error", "code": "UNKNOWN_TOPIC", "message": "...", "id": "1"}
+//
+// Published messages carry their topic's seq, which goes up by one with
+// every message of the topic. The subscribed reply has the seq each topic is
+// at, so a client can resume from there before any message came. Subscribing to a topic that has a snapshot
+// first gets the snapshot, right after the reply:
+//
+//	{"tag": "snapshot", "topic": "trade:AA", "seq": 41, "data": [...]}
+//	{"tag": "trade", "topic": "trade:AA", "seq": 42, "data": {...}}
+//
+// A client that lost its connection resumes a topic from the last seq it
+// got, and gets the messages after it, or, when they are no longer kept,
+// is told it needs a snapshot: the hub's if the topic has one, otherwise
+// one of its own. Either way it is subscribed again.
+//
+//	{"event": "resumed", "topics": ["trade:AA"], "seq": 41, "id": "5"}
+//	{"event": "snapshot_required", "topics": ["trade:AA"], "seq": 977, "id": "5"}
+//
+// A login is checked by the hub's authenticator and holds for the rest of
+// the connection. Private channels, such as "orders", are subscribed to by
+// their bare name once logged in and carry only the client's own account.
+// Other ops are served by the Handler registered for them, for logged in
+// clients only; their reply has the op as event and the result as data.
+//
+// A request with an unknown topic is refused as a whole. Requests are never
+// passed on to other clients.
+const (
+	OpSubscribe   = "subscribe"
+	OpUnsubscribe = "unsubscribe"
+	OpResume      = "resume"
+	OpPing        = "ping"
+	OpLogin       = "login"
+
+	EventSubscribed   = "subscribed"
+	EventUnsubscribed = "unsubscribed"
+	EventResumed      = "resumed"
+
+	EventSnapshotRequired = "snapshot_required"
+	EventPong             = "pong"
+	EventLogin            = "login"
+	EventError            = "error"
+
+	ErrBadRequest      = "BAD_REQUEST"
+	ErrUnknownOp       = "UNKNOWN_OP"
+	ErrUnknownTopic    = "UNKNOWN_TOPIC"
+	ErrNoTopics        = "NO_TOPICS"
+	ErrUnauthorized    = "UNAUTHORIZED"
+	ErrUnauthenticated = "UNAUTHENTICATED"
+	ErrAlreadyLoggedIn = "ALREADY_LOGGED_IN"
+)
+
+// Login is a login request: either Token, or AccountId with a Signature of
+// Timestamp.
+type Login struct {
+	Token     string `json:"token,omitempty"`
+	AccountId string `json:"account,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type request struct {
+	Id     string   `json:"id,omitempty"`
+	Op     string   `json:"op"`
+	Topics []string `json:"topics"`
+	Topic  string   `json:"topic"`
+	Seq    uint64   `json:"seq"`
+	Login
+}
+
+type reply struct {
+	Id      string            `json:"id,omitempty"`
+	Event   string            `json:"event"`
+	Topics  []string          `json:"topics,omitempty"`
+	Seq     uint64            `json:"seq,omitempty"`
+	Seqs    map[string]uint64 `json:"seqs,omitempty"`
+	Account string            `json:"account,omitempty"`
+	Session string            `json:"session,omitempty"`
+	Code    string            `json:"code,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Time    int64             `json:"time,omitempty"`
+	Data    interface{}       `json:"data,omitempty"`
+}
+
+// Error is the error reply of a Handler.
+type Error struct {
+	Code    string
+	Message string
+}
+
+// Session is a logged in connection.
+type Session struct {
+	Id        string
+	AccountId string
+}
+
+// Handler serves requests of one op from logged in clients. It is given the
+// client's session and the raw request, and returns the data of the reply,
+// whose event is the op, or an error. Requests of one client are handled one
+// at a time, in order.
+type Handler func(session Session, request []byte) (interface{}, *Error)
+
+// Handle registers the handler of an op. It must be called before Run.
+func (h *Hub) Handle(op string, handler Handler) {
+	h.handlers[op] = handler
+}
+
+// OnDisconnect sets what to do when the connection of a logged in
+// websocket client ends, for whatever reason. It is called from the
+// client's goroutine. It must be called before Run.
+func (h *Hub) OnDisconnect(fn func(session Session)) {
+	h.onDisconnect = fn
+}
+
+// command is work for the client's shard: a subscription change, or a
+// reply to deliver. The hub works out a subscription change: the topics to
+// change and what to deliver then, see Hub.subscription.
+type command struct {
+	client *Client
+	req    *request
+	keys   []string
+	frames []frame
+	reply  []byte
+}
+
+// serve handles one request read from the client. Subscriptions are changed
+// by the client's shard, by way of the hub, everything else is answered
+// here, so a slow handler holds up only its own client.
+func (c *Client) serve(message []byte) {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		c.reply(reply{Event: EventError, Code: ErrBadRequest, Message: err.Error()})
+		return
+	}
+
+	switch req.Op {
+	case OpSubscribe, OpUnsubscribe, OpResume:
+		c.hub.commands <- command{client: c, req: &req}
+	case OpPing:
+		c.reply(reply{Id: req.Id, Event: EventPong, Time: time.Now().UnixMilli()})
+	case OpLogin:
+		c.login(req)
+	default:
+		handler, ok := c.hub.handlers[req.Op]
+		if !ok {
+			c.reply(reply{Id: req.Id, Event: EventError, Code: ErrUnknownOp, Message: "unknown op " + req.Op})
+			return
+		}
+		accountId := c.account()
+		if accountId == "" {
+			c.reply(reply{Id: req.Id, Event: EventError, Code: ErrUnauthenticated, Message: "login before " + req.Op})
+			return
+		}
+		data, err := handler(Session{Id: c.id, AccountId: accountId}, message)
+		if err != nil {
+			c.reply(reply{Id: req.Id, Event: EventError, Code: err.Code, Message: err.Message})
+			return
+		}
+		c.reply(reply{Id: req.Id, Event: req.Op, Data: data})
+	}
+}
+
+func (c *Client) login(req request) {
+	if accountId := c.account(); accountId != "" {
+		c.reply(reply{Id: req.Id, Event: EventError, Code: ErrAlreadyLoggedIn, Message: "already logged in as " + accountId})
+		return
+	}
+	if c.hub.authenticate == nil {
+		c.reply(reply{Id: req.Id, Event: EventError, Code: ErrUnauthorized, Message: "login is not available"})
+		return
+	}
+	accountId, err := c.hub.authenticate(req.Login)
+	if err != nil {
+		c.reply(reply{Id: req.Id, Event: EventError, Code: ErrUnauthorized, Message: err.Error()})
+		return
+	}
+
+	c.mu.Lock()
+	c.accountId = accountId
+	c.mu.Unlock()
+	c.reply(reply{Id: req.Id, Event: EventLogin, Account: accountId, Session: c.id})
+}
+
+func (c *Client) account() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.accountId
+}
+
+// reply hands a reply to the client's shard, which delivers it in order with
+// the client's other messages.
+func (c *Client) reply(r reply) {
+	message, _ := json.Marshal(r)
+	c.shard.commands <- command{client: c, reply: message}
+}
+
+// subscription works out a subscription change of a client, in the hub
+// goroutine, which numbers the messages.
+func (h *Hub) subscription(cmd *command) {
+	req := cmd.req
+	topics := req.Topics
+	if req.Op == OpResume {
+		topics = []string{}
+		if req.Topic != "" {
+			topics = append(topics, req.Topic)
+		}
+	}
+	refuse := func(code, message string) {
+		cmd.frames = []frame{replyFrame(reply{Id: req.Id, Event: EventError, Code: code, Message: message})}
+	}
+	if len(topics) == 0 {
+		refuse(ErrNoTopics, "no topics given")
+		return
+	}
+
+	accountId := cmd.client.account()
+	keys := make([]string, 0, len(topics))
+	unknown := []string{}
+	for _, topic := range topics {
+		if h.privateChannels[topic] {
+			if accountId == "" {
+				refuse(ErrUnauthenticated, "login before subscribing to "+topic)
+				return
+			}
+			keys = append(keys, PrivateTopic(topic, accountId))
+			continue
+		}
+		if h.validTopic == nil || !h.validTopic(topic) {
+			unknown = append(unknown, topic)
+		}
+		keys = append(keys, topic)
+	}
+	if len(unknown) > 0 {
+		refuse(ErrUnknownTopic, "unknown topics "+strings.Join(unknown, ", "))
+		return
+	}
+
+	cmd.keys = keys
+	switch req.Op {
+	case OpSubscribe:
+		seqs := make(map[string]uint64, len(topics))
+		for i, topic := range topics {
+			if state, ok := h.topics[keys[i]]; ok {
+				seqs[topic] = state.seq
+			} else {
+				seqs[topic] = 0
+			}
+		}
+		r := reply{Id: req.Id, Event: EventSubscribed, Topics: topics, Seqs: seqs}
+		cmd.frames = append([]frame{replyFrame(r)}, h.snapshot(keys...)...)
+	case OpUnsubscribe:
+		cmd.frames = []frame{replyFrame(reply{Id: req.Id, Event: EventUnsubscribed, Topics: topics})}
+	case OpResume:
+		cmd.frames = h.resume(req, keys[0])
+	}
+}
+
+// resume is the reply to a resume of topic and the messages that follow it.
+func (h *Hub) resume(req *request, topic string) []frame {
+	state, ok := h.topics[topic]
+	if !ok {
+		state = &topicState{}
+	}
+	if req.Seq > state.seq || req.Seq+1 < state.oldest() {
+		r := reply{Id: req.Id, Event: EventSnapshotRequired, Topics: []string{req.Topic}, Seq: state.seq}
+		return append([]frame{replyFrame(r)}, h.snapshot(topic)...)
+	}
+
+	frames := []frame{replyFrame(reply{Id: req.Id, Event: EventResumed, Topics: []string{req.Topic}, Seq: req.Seq})}
+	class := h.class(topic)
+	for _, p := range state.replay[req.Seq+1-state.oldest():] {
+		frames = append(frames, frame{topic: topic, class: class, payload: p})
+	}
+	return frames
+}
+
+func replyFrame(r reply) frame {
+	message, _ := json.Marshal(r)
+	return frame{payload: newPayload(message), class: Critical}
+}
+
+func (s *shard) handle(cmd command) {
+	if cmd.req == nil {
+		s.deliver(cmd.client, "", newPayload(cmd.reply), Critical)
+		return
+	}
+
+	for _, key := range cmd.keys {
+		if cmd.req.Op == OpUnsubscribe {
+			s.unsubscribe(cmd.client, key)
+		} else {
+			s.subscribe(cmd.client, key)
+		}
+	}
+	for _, f := range cmd.frames {
+		s.deliver(cmd.client, f.topic, f.payload, f.class)
+	}
+}
@@ -0,0 +1,122 @@
+package wss
+
+import (
+	"log"
+)
+
+// frame is a published message, encoded once for every shard, or a
+// subscription change of one of the shard's clients.
+type frame struct {
+	topic   string
+	class   Class
+	payload *payload
+
+	cmd *command
+}
+
+// shard owns a share of the hub's clients: their subscriptions and the
+// delivery of messages to them. Only the shard goroutine touches them.
+type shard struct {
+	hub *Hub
+
+	// Registered clients.
+	clients map[*Client]bool
+
+	// The clients subscribed to each topic.
+	subscribers map[string]map[*Client]bool
+
+	// Messages from the hub.
+	broadcast chan frame
+
+	// Register requests from the hub.
+	register chan *Client
+
+	// Unregister requests from clients.
+	unregister chan *Client
+
+	// Requests read from the clients, see protocol.go.
+	commands chan command
+
+	// Requests for the clients' queue metrics.
+	stats chan chan []ClientStats
+}
+
+func newShard(hub *Hub) *shard {
+	return &shard{
+		hub:         hub,
+		clients:     make(map[*Client]bool),
+		subscribers: make(map[string]map[*Client]bool),
+		broadcast:   make(chan frame, 1024),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		commands:    make(chan command),
+		stats:       make(chan chan []ClientStats),
+	}
+}
+
+func (s *shard) run() {
+	for {
+		select {
+		case client := <-s.register:
+			s.clients[client] = true
+		case client := <-s.unregister:
+			if _, ok := s.clients[client]; ok {
+				s.drop(client, "")
+			}
+		case cmd := <-s.commands:
+			if _, ok := s.clients[cmd.client]; ok {
+				s.handle(cmd)
+			}
+		case res := <-s.stats:
+			stats := make([]ClientStats, 0, len(s.clients))
+			for client := range s.clients {
+				stats = append(stats, client.stats())
+			}
+			res <- stats
+		case f := <-s.broadcast:
+			if f.cmd != nil {
+				if _, ok := s.clients[f.cmd.client]; ok {
+					s.handle(*f.cmd)
+				}
+				continue
+			}
+			for client := range s.subscribers[f.topic] {
+				s.deliver(client, f.topic, f.payload, f.class)
+			}
+		}
+	}
+}
+
+func (s *shard) subscribe(client *Client, topic string) {
+	client.topics[topic] = true
+	if s.subscribers[topic] == nil {
+		s.subscribers[topic] = make(map[*Client]bool)
+	}
+	s.subscribers[topic][client] = true
+}
+
+func (s *shard) unsubscribe(client *Client, topic string) {
+	delete(client.topics, topic)
+	delete(s.subscribers[topic], client)
+	if len(s.subscribers[topic]) == 0 {
+		delete(s.subscribers, topic)
+	}
+}
+
+// deliver queues a message for a client, in its encoding. It never blocks:
+// a client that falls too far behind is disconnected instead.
+func (s *shard) deliver(client *Client, topic string, p *payload, class Class) {
+	if !client.send.push(topic, p.encode(client.encoding), class) {
+		log.Printf("wss: disconnecting %s: %s", client.remote, CloseSlowConsumer)
+		s.drop(client, CloseSlowConsumer)
+	}
+}
+
+// drop forgets a client and has its connection closed with reason.
+func (s *shard) drop(client *Client, reason string) {
+	for topic := range client.topics {
+		s.unsubscribe(client, topic)
+	}
+	delete(s.clients, client)
+	client.send.close(reason)
+}
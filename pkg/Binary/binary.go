@@ -0,0 +1,147 @@
+// Package Binary encodes depth and trade messages in the compact binary
+// layouts sent to WS clients with the binary encoding, and decodes trades
+// back. All fields are big endian; prices, quantities and amounts are fixed
+// point with Scale decimals.
+//
+// depth:
+//
+//	0   u8   binaryDepth
+//	1   u64  seq
+//	9   u32  checksum
+//	13  i64  step
+//	21  u8   bid levels, n
+//	22  u8   ask levels, m
+//	23  n bids then m asks, best first, each i64 price and i64 quantity
+//
+// trade, 41 bytes:
+//
+//	0   u8   binaryTrade
+//	1   u64  seq
+//	9   i64  price
+//	17  i64  quantity
+//	25  i64  amount
+//	33  i64  time, unix seconds
+package Binary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	TypeDepth = 0x01
+	TypeTrade = 0x02
+
+	Scale = 8
+
+	tradeSize = 41
+)
+
+var ErrMalformed = errors.New("malformed binary message")
+
+// Trade is a decoded trade message.
+type Trade struct {
+	Seq      uint64
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+	Amount   decimal.Decimal
+	Time     int64
+}
+
+// fixedPoint is a decimal string as a Scale fixed point integer.
+func fixedPoint(s string) (int64, error) {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return 0, err
+	}
+	return d.Shift(Scale).IntPart(), nil
+}
+
+// EncodeDepth encodes the JSON data of a depth message.
+func EncodeDepth(topic string, seq uint64, data []byte) ([]byte, error) {
+	var depth struct {
+		Ask      [][2]string `json:"ask"`
+		Bid      [][2]string `json:"bid"`
+		Step     string      `json:"step"`
+		Checksum uint32      `json:"checksum"`
+	}
+	if err := json.Unmarshal(data, &depth); err != nil {
+		return nil, err
+	}
+	if len(depth.Bid) > 255 || len(depth.Ask) > 255 {
+		return nil, fmt.Errorf("%s: too many levels", topic)
+	}
+	step, err := fixedPoint(depth.Step)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(TypeDepth)
+	binary.Write(&b, binary.BigEndian, seq)
+	binary.Write(&b, binary.BigEndian, depth.Checksum)
+	binary.Write(&b, binary.BigEndian, step)
+	b.WriteByte(byte(len(depth.Bid)))
+	b.WriteByte(byte(len(depth.Ask)))
+	for _, level := range append(depth.Bid, depth.Ask...) {
+		for _, field := range level {
+			v, err := fixedPoint(field)
+			if err != nil {
+				return nil, err
+			}
+			binary.Write(&b, binary.BigEndian, v)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// EncodeTrade encodes the JSON data of a trade message.
+func EncodeTrade(topic string, seq uint64, data []byte) ([]byte, error) {
+	var trade struct {
+		TradePrice    string
+		TradeQuantity string
+		TradeAmount   string
+		TradeTime     int64
+	}
+	if err := json.Unmarshal(data, &trade); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(TypeTrade)
+	binary.Write(&b, binary.BigEndian, seq)
+	for _, field := range []string{trade.TradePrice, trade.TradeQuantity, trade.TradeAmount} {
+		v, err := fixedPoint(field)
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	binary.Write(&b, binary.BigEndian, trade.TradeTime)
+	return b.Bytes(), nil
+}
+
+// DecodeTrade decodes a trade message.
+func DecodeTrade(message []byte) (Trade, error) {
+	if len(message) != tradeSize || message[0] != TypeTrade {
+		return Trade{}, ErrMalformed
+	}
+	var fields struct {
+		Seq                     uint64
+		Price, Quantity, Amount int64
+		Time                    int64
+	}
+	binary.Read(bytes.NewReader(message[1:]), binary.BigEndian, &fields)
+	return Trade{
+		Seq:      fields.Seq,
+		Price:    decimal.New(fields.Price, -Scale),
+		Quantity: decimal.New(fields.Quantity, -Scale),
+		Amount:   decimal.New(fields.Amount, -Scale),
+		Time:     fields.Time,
+	}, nil
+}
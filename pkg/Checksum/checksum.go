@@ -0,0 +1,125 @@
+// Package Checksum computes and verifies the CRC32 checksum carried by depth
+// and depth_update messages, so a client can tell that its copy of the book
+// matches the exchange's.
+//
+// The checksum covers the best Levels levels of each side, each level being
+// the [price, quantity] strings exactly as they appear in the messages (for
+// example "10.00" and "1.5000"; do not reformat them). The canonical string
+// interleaves the sides level by level, bid before ask, joining every field
+// with ":":
+//
+//	bid1price:bid1qty:ask1price:ask1qty:bid2price:bid2qty:ask2price:ask2qty:...
+//
+// A side with fewer than Levels levels simply contributes fewer fields, and
+// an empty book gives the empty string. The checksum is the CRC32 (IEEE
+// polynomial) of that string as an unsigned 32-bit integer.
+package Checksum
+
+import (
+	"hash/crc32"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Levels is the number of levels per side covered by a checksum.
+const Levels = 10
+
+// Canonical returns the string a checksum is computed over. bids and asks
+// are best first.
+func Canonical(bids, asks [][2]string) string {
+	parts := make([]string, 0, 4*Levels)
+	for i := 0; i < Levels; i++ {
+		if i < len(bids) {
+			parts = append(parts, bids[i][0], bids[i][1])
+		}
+		if i < len(asks) {
+			parts = append(parts, asks[i][0], asks[i][1])
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// Compute returns the checksum of a book whose sides are given best first.
+func Compute(bids, asks [][2]string) uint32 {
+	return crc32.ChecksumIEEE([]byte(Canonical(bids, asks)))
+}
+
+// Verify reports whether a book whose sides are given best first matches
+// checksum.
+func Verify(bids, asks [][2]string, checksum uint32) bool {
+	return Compute(bids, asks) == checksum
+}
+
+// Book is a client side copy of an order book kept from a depth snapshot and
+// depth_update messages.
+type Book struct {
+	bids map[string]string
+	asks map[string]string
+}
+
+// NewBook starts a book from the levels of a snapshot.
+func NewBook(bids, asks [][2]string) *Book {
+	b := &Book{
+		bids: make(map[string]string),
+		asks: make(map[string]string),
+	}
+	b.Apply(bids, asks)
+	return b
+}
+
+// Apply applies the changed levels of an update; a quantity that is zero
+// removes the level.
+func (b *Book) Apply(bids, asks [][2]string) {
+	apply(b.bids, bids)
+	apply(b.asks, asks)
+}
+
+func apply(side map[string]string, levels [][2]string) {
+	for _, level := range levels {
+		if quantity, err := decimal.NewFromString(level[1]); err == nil && quantity.IsZero() {
+			delete(side, level[0])
+		} else {
+			side[level[0]] = level[1]
+		}
+	}
+}
+
+// Levels returns up to n levels of each side, best first, or every level
+// when n is not positive.
+func (b *Book) Levels(n int) (bids, asks [][2]string) {
+	return sorted(b.bids, n, true), sorted(b.asks, n, false)
+}
+
+// Verify reports whether the book matches checksum.
+func (b *Book) Verify(checksum uint32) bool {
+	bids, asks := b.Levels(Levels)
+	return Verify(bids, asks, checksum)
+}
+
+func sorted(side map[string]string, n int, desc bool) [][2]string {
+	type level struct {
+		price decimal.Decimal
+		raw   [2]string
+	}
+	levels := make([]level, 0, len(side))
+	for price, quantity := range side {
+		p, _ := decimal.NewFromString(price)
+		levels = append(levels, level{price: p, raw: [2]string{price, quantity}})
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if desc {
+			return levels[i].price.GreaterThan(levels[j].price)
+		}
+		return levels[i].price.LessThan(levels[j].price)
+	})
+	if n <= 0 || n > len(levels) {
+		n = len(levels)
+	}
+	res := make([][2]string, 0, n)
+	for _, l := range levels[:n] {
+		res = append(res, l.raw)
+	}
+	return res
+}
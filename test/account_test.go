@@ -0,0 +1,185 @@
+package test
+
+import (
+	"testing"
+
+	. "github.com/User/internal/pkg/Account"
+	. "github.com/User/internal/pkg/Ledger"
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/Queue"
+	. "github.com/User/internal/pkg/Snapshot"
+	"github.com/shopspring/decimal"
+)
+
+func TestAccountHoldAndSettle(t *testing.T) {
+	accounts := NewAccounts()
+	accounts.Deposit("seller", "AA", d(10))
+	accounts.Deposit("buyer", "USDT", d(100))
+
+	ask := Order{OrderId: "a-1", AccountId: "seller", Quantity: d(10), Price: d(5), OrderType: OrderSell, PriceType: PriceLimit}
+	bid := Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(4), Price: d(5), OrderType: OrderBuy, PriceType: PriceLimit}
+	if err := accounts.Hold(ask, "AA", "USDT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.Hold(bid, "AA", "USDT"); err != nil {
+		t.Fatal(err)
+	}
+
+	tooBig := Order{OrderId: "b-2", AccountId: "buyer", Quantity: d(100), Price: d(5), OrderType: OrderBuy, PriceType: PriceLimit}
+	if err := accounts.Hold(tooBig, "AA", "USDT"); err != ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+
+	if _, err := accounts.Settle("a-1", "b-1", d(4), d(20)); err != nil {
+		t.Fatal(err)
+	}
+
+	buyer := accounts.Balances("buyer")
+	if !buyer["AA"].Available.Equal(d(4)) || !buyer["USDT"].Available.Equal(d(80)) || !buyer["USDT"].Held.IsZero() {
+		t.Fatalf("unexpected buyer balances %+v", buyer)
+	}
+	seller := accounts.Balances("seller")
+	if !seller["USDT"].Available.Equal(d(20)) || !seller["AA"].Held.Equal(d(6)) {
+		t.Fatalf("unexpected seller balances %+v", seller)
+	}
+
+	accounts.Release("a-1")
+	seller = accounts.Balances("seller")
+	if !seller["AA"].Available.Equal(d(6)) || !seller["AA"].Held.IsZero() {
+		t.Fatalf("unexpected seller balances after release %+v", seller)
+	}
+}
+
+func TestAccountAmend(t *testing.T) {
+	accounts := NewAccounts()
+	accounts.Deposit("buyer", "USDT", d(100))
+
+	bid := Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(4), Price: d(5), OrderType: OrderBuy, PriceType: PriceLimit}
+	if err := accounts.Hold(bid, "AA", "USDT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.Amend("b-1", "buyer", d(10), d(6)); err != nil {
+		t.Fatal(err)
+	}
+	if b := accounts.Balances("buyer")["USDT"]; !b.Held.Equal(d(60)) || !b.Available.Equal(d(40)) {
+		t.Fatalf("unexpected balance after amend %+v", b)
+	}
+
+	if err := accounts.Amend("b-1", "other", d(5), d(1)); err != ErrUnknownHold {
+		t.Fatalf("expected ErrUnknownHold, got %v", err)
+	}
+	if err := accounts.Amend("b-1", "buyer", d(10), d(11)); err != ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+	if err := accounts.Amend("b-1", "buyer", d(5), d(1)); err != nil {
+		t.Fatal(err)
+	}
+	if b := accounts.Balances("buyer")["USDT"]; !b.Held.Equal(d(5)) || !b.Available.Equal(d(95)) {
+		t.Fatalf("unexpected balance after amend %+v", b)
+	}
+}
+
+// accountFunds holds and settles the orders of a ticker in accounts and
+// ledger, like the server does.
+type accountFunds struct {
+	accounts *Accounts
+	ledger   *Ledger
+}
+
+func (f accountFunds) Hold(order Order) error {
+	return f.accounts.Hold(order, "AA", "USDT")
+}
+
+func (f accountFunds) Amend(amend Order) error {
+	return f.accounts.Amend(amend.OrderId, amend.AccountId, amend.Price, amend.Quantity)
+}
+
+func (f accountFunds) Settle(trade TradeResult) (decimal.Decimal, decimal.Decimal, error) {
+	s, err := f.accounts.Settle(trade.AskOrderId, trade.BidOrderId, trade.TradeQuantity, trade.TradeAmount)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	f.ledger.Record(trade.TradeId, trade.TradeTime, s)
+	return s.BuyerFee, s.SellerFee, nil
+}
+
+func (f accountFunds) Release(orderId string) {
+	f.accounts.Release(orderId)
+}
+
+func (f accountFunds) Deposit(accountId, asset string, amount decimal.Decimal) error {
+	return f.accounts.Deposit(accountId, asset, amount)
+}
+
+func (f accountFunds) SetFeeRate(rate decimal.Decimal) {
+	f.accounts.SetFeeRate(rate)
+}
+
+func (f accountFunds) Save(s *Snapshot) {
+	s.Accounts = f.accounts.State()
+	s.Postings = f.ledger.Postings()
+}
+
+func (f accountFunds) Load(s Snapshot) {
+	f.accounts.Restore(s.Accounts)
+	f.ledger.Restore(s.Postings)
+}
+
+func TestAccountAmendAfterFill(t *testing.T) {
+	accounts := NewAccounts()
+	accounts.SetFeeRate(d(0.001))
+	ledger := NewLedger()
+	ticker := NewQueueTicker("AA")
+	ticker.SetFunds(accountFunds{accounts: accounts, ledger: ledger})
+	trades := drainTrades(ticker)
+	accounts.Deposit("seller", "AA", d(10))
+	accounts.Deposit("buyer", "USDT", d(100))
+
+	bid := Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(10), Price: d(10), CreateTime: 1, OrderType: OrderBuy, PriceType: PriceLimit}
+	if err := ticker.PushNewOrder(bid); err != nil {
+		t.Fatal(err)
+	}
+	ask := Order{OrderId: "a-1", AccountId: "seller", Quantity: d(5), Price: d(10), CreateTime: 2, OrderType: OrderSell, PriceType: PriceLimit}
+	if err := ticker.PushNewOrder(ask); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fill is settled by the time the amend sees the hold, whether or
+	// not the trade was read yet
+	if err := ticker.AmendOrder(Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(5), Price: d(10), CreateTime: 3, OrderType: OrderBuy}); err != nil {
+		t.Fatal(err)
+	}
+	if trade := <-trades; !trade.BuyerFee.Equal(d(0.005)) || !trade.SellerFee.Equal(d(0.05)) {
+		t.Fatalf("unexpected fees %s %s", trade.BuyerFee, trade.SellerFee)
+	}
+	if b := accounts.Balances("buyer")["USDT"]; !b.Held.Equal(d(50)) || !b.Available.Equal(d(0)) {
+		t.Fatalf("unexpected buyer balance after amend %+v", b)
+	}
+	if err := ticker.AmendOrder(Order{OrderId: "b-1", AccountId: "seller", Quantity: d(1), Price: d(10), CreateTime: 4, OrderType: OrderBuy}); err != ErrUnknownOrder {
+		t.Fatalf("expected ErrUnknownOrder, got %v", err)
+	}
+	if err := ticker.AmendOrder(Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(6), Price: d(10), CreateTime: 4, OrderType: OrderBuy}); err != ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+
+	ask = Order{OrderId: "a-2", AccountId: "seller", Quantity: d(5), Price: d(10), CreateTime: 5, OrderType: OrderSell, PriceType: PriceLimit}
+	if err := ticker.PushNewOrder(ask); err != nil {
+		t.Fatal(err)
+	}
+	<-trades
+	if b := accounts.Balances("buyer")["USDT"]; !b.Held.IsZero() || !b.Available.IsZero() {
+		t.Fatalf("unexpected buyer balance after the second fill %+v", b)
+	}
+	if err := ledger.Check(accounts); err != nil {
+		t.Fatal(err)
+	}
+
+	// an order its account cannot cover never reaches the book
+	tooBig := Order{OrderId: "b-2", AccountId: "buyer", Quantity: d(1), Price: d(10), CreateTime: 6, OrderType: OrderBuy, PriceType: PriceLimit}
+	if err := ticker.PushNewOrder(tooBig); err != ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+	if ticker.BidLen() != 0 {
+		t.Fatalf("expected an empty bid book, got %d orders", ticker.BidLen())
+	}
+}
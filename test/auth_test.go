@@ -0,0 +1,54 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/User/internal/pkg/Auth"
+)
+
+func TestAuthKeys(t *testing.T) {
+	keys := NewKeys()
+	keys.Add(Credentials{AccountId: "alice", Token: "alice-token", Secret: "alice-secret"})
+	generated, err := keys.Generate("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account, err := keys.Token("alice-token"); err != nil || account != "alice" {
+		t.Fatalf("token login: %s %v", account, err)
+	}
+	if account, err := keys.Token(generated.Token); err != nil || account != "bob" {
+		t.Fatalf("generated token login: %s %v", account, err)
+	}
+	if _, err := keys.Token("nope"); err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	now := time.Now()
+	ts := now.UnixMilli()
+	if err := keys.Verify("alice", ts, Sign("alice-secret", "alice", ts), now); err != nil {
+		t.Fatalf("signed login: %v", err)
+	}
+	if err := keys.Verify("bob", ts, Sign("alice-secret", "bob", ts), now); err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized for the wrong secret, got %v", err)
+	}
+	if err := keys.Verify("alice", ts, Sign("alice-secret", "alice", ts), now.Add(time.Minute)); err != ErrExpired {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+
+	// credentials without a secret only log in by token, an empty secret
+	// must not verify anything
+	if err := keys.Add(Credentials{AccountId: "carol", Token: "carol-token"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Verify("carol", ts, Sign("", "carol", ts), now); err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized for an empty secret, got %v", err)
+	}
+	if account, err := keys.Token("carol-token"); err != nil || account != "carol" {
+		t.Fatalf("token login: %s %v", account, err)
+	}
+	if err := keys.Add(Credentials{AccountId: "dave"}); err != ErrEmpty {
+		t.Fatalf("expected ErrEmpty, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/User/pkg/Binary"
+)
+
+func TestBinaryTradeRoundTrip(t *testing.T) {
+	// the trade data as published, TradeTime in unix seconds
+	now := time.Now().Unix()
+	data, _ := json.Marshal(map[string]interface{}{
+		"TradePrice":    "10.5000",
+		"TradeQuantity": "2.0000",
+		"TradeAmount":   "21.0000",
+		"TradeTime":     now,
+	})
+
+	message, err := Binary.EncodeTrade("trade:AA", 7, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message) != 41 || message[0] != Binary.TypeTrade {
+		t.Fatalf("unexpected layout %x", message)
+	}
+	trade, err := Binary.DecodeTrade(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trade.Seq != 7 || !trade.Price.Equal(d(10.5)) || !trade.Quantity.Equal(d(2)) || !trade.Amount.Equal(d(21)) {
+		t.Fatalf("unexpected trade %+v", trade)
+	}
+	if trade.Time != now || time.Unix(trade.Time, 0).Year() != time.Now().Year() {
+		t.Fatalf("time %d does not round trip as unix seconds %d", trade.Time, now)
+	}
+
+	if _, err := Binary.DecodeTrade(message[:40]); err != Binary.ErrMalformed {
+		t.Fatalf("truncated message decoded: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/User/internal/pkg/Depth"
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/Queue"
+	"github.com/shopspring/decimal"
+)
+
+func TestTickerDepth(t *testing.T) {
+	ticker := NewQueueTicker("DEPTH")
+	trades := drainTrades(ticker)
+
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(1), Price: d(10), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-2", Quantity: d(2), Price: d(10), CreateTime: 2, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-3", Quantity: d(5), Price: d(11), CreateTime: 3, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(4), Price: d(9), CreateTime: 4, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-2", Quantity: d(1), Price: d(8.5), CreateTime: 5, OrderType: OrderBuy, PriceType: PriceLimit})
+
+	if ask := ticker.GetAskDepth(0); !reflect.DeepEqual(ask, [][2]string{{"10.00", "3.0000"}, {"11.00", "5.0000"}}) {
+		t.Fatalf("unexpected ask depth %v", ask)
+	}
+	if bid := ticker.GetBidDepth(1); !reflect.DeepEqual(bid, [][2]string{{"9.00", "4.0000"}}) {
+		t.Fatalf("unexpected bid depth %v", bid)
+	}
+
+	// a fill is visible at once, without waiting for a rebuild
+	ticker.PushNewOrder(Order{OrderId: "b-3", Quantity: d(1.5), Price: d(10), CreateTime: 6, OrderType: OrderBuy, PriceType: PriceLimit})
+	<-trades
+	<-trades
+	if ask := ticker.GetAskDepth(1); !reflect.DeepEqual(ask, [][2]string{{"10.00", "1.5000"}}) {
+		t.Fatalf("unexpected ask depth after fill %v", ask)
+	}
+
+	ticker.CancelOrder(OrderSell, "a-2")
+	if ask := ticker.GetAskDepth(0); !reflect.DeepEqual(ask, [][2]string{{"11.00", "5.0000"}}) {
+		t.Fatalf("unexpected ask depth after cancel %v", ask)
+	}
+	ticker.AmendOrder(Order{OrderId: "b-1", Quantity: d(3), Price: d(9), CreateTime: 7, OrderType: OrderBuy})
+	if bid := ticker.GetBidDepth(0); !reflect.DeepEqual(bid, [][2]string{{"9.00", "3.0000"}, {"8.50", "1.0000"}}) {
+		t.Fatalf("unexpected bid depth after amend %v", bid)
+	}
+}
+
+const benchOrders = 100000
+
+func benchQueue() *OrderQueue {
+	queue := NewSideQueue(OrderSell)
+	for i := 0; i < benchOrders; i++ {
+		queue.En(Order{
+			OrderId:    fmt.Sprintf("a-%d", i),
+			Quantity:   d(1),
+			Price:      decimal.NewFromInt(int64(1000 + i%2000)).Div(decimal.NewFromInt(100)),
+			CreateTime: int64(i),
+			OrderType:  OrderSell,
+			PriceType:  PriceLimit,
+		})
+	}
+	return queue
+}
+
+// rebuildDepth is how depth used to be produced, as the engine's depthTicker
+// did every 100ms before depth was maintained: aggregate every resting order
+// into a map of formatted strings and quicksort the prices, then take the top.
+func rebuildDepth(queue *OrderQueue, size int) [][2]string {
+	depthMap := make(map[string]string)
+	for i := 0; i < queue.Pq.Len(); i++ {
+		item := (*queue.Pq)[i]
+
+		price := FormatDecimal2String(item.Price, 2)
+		if _, ok := depthMap[price]; !ok {
+			depthMap[price] = FormatDecimal2String(item.Quantity, 4)
+		} else {
+			old_qunantity, _ := decimal.NewFromString(depthMap[price])
+			depthMap[price] = FormatDecimal2String(old_qunantity.Add(item.Quantity), 4)
+		}
+	}
+
+	depth := sortMap2Slice(depthMap, queue.Top().OrderType)
+	if size > 0 && size < len(depth) {
+		depth = depth[:size]
+	}
+	return depth
+}
+
+func sortMap2Slice(m map[string]string, ask_bid OrderType) [][2]string {
+	res := [][2]string{}
+	keys := []string{}
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+
+	if ask_bid == OrderSell {
+		keys = quickSort(keys, "asc")
+	} else {
+		keys = quickSort(keys, "desc")
+	}
+
+	for _, k := range keys {
+		res = append(res, [2]string{k, m[k]})
+	}
+	return res
+}
+
+func quickSort(nums []string, asc_desc string) []string {
+	if len(nums) <= 1 {
+		return nums
+	}
+
+	spilt := nums[0]
+	left := []string{}
+	right := []string{}
+	mid := []string{}
+
+	for _, v := range nums {
+		vv, _ := decimal.NewFromString(v)
+		sp, _ := decimal.NewFromString(spilt)
+		if vv.Cmp(sp) == -1 {
+			left = append(left, v)
+		} else if vv.Cmp(sp) == 1 {
+			right = append(right, v)
+		} else {
+			mid = append(mid, v)
+		}
+	}
+
+	left = quickSort(left, asc_desc)
+	right = quickSort(right, asc_desc)
+
+	if asc_desc == "asc" {
+		return append(append(left, mid...), right...)
+	} else {
+		return append(append(right, mid...), left...)
+	}
+}
+
+func BenchmarkDepthRebuild(b *testing.B) {
+	queue := benchQueue()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rebuildDepth(queue, 10)
+	}
+}
+
+func BenchmarkDepthTop(b *testing.B) {
+	queue := benchQueue()
+	if !reflect.DeepEqual(queue.Depth(10), rebuildDepth(queue, 10)) {
+		b.Fatal("maintained depth differs from rebuilt depth")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queue.Depth(10)
+	}
+}
+
+// BenchmarkDepthUpdate is what each book change pays instead: an insert and
+// a removal, including the id lookup the engine does before removing.
+func BenchmarkDepthUpdate(b *testing.B) {
+	queue := benchQueue()
+	order := Order{OrderId: "a-new", Quantity: d(1), Price: d(15.555), OrderType: OrderSell, PriceType: PriceLimit}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queue.En(order)
+		_, index := queue.GetIndexByUnId(order.OrderId)
+		queue.Remove(index)
+	}
+}
+
+// BenchmarkDepthFlush is what a depth_update costs now: the book events of
+// an insert and a removal, then the update they make.
+func BenchmarkDepthFlush(b *testing.B) {
+	ticker := NewQueueTicker("BENCH")
+	for _, order := range *benchQueue().Pq {
+		ticker.PushNewOrder(order)
+	}
+	book := Depth.NewBook("BENCH")
+	book.Load(ticker.BookSnapshot())
+	book.Flush()
+
+	seq := ticker.BookSeq()
+	event := BookEvent{OrderId: "a-new", Side: "ask", Price: d(15.555), Quantity: d(1)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event.Seq, event.Type = seq+1, BookAdd
+		book.Apply(event)
+		event.Seq, event.Type = seq+2, BookDelete
+		book.Apply(event)
+		seq += 2
+		book.Flush()
+	}
+}
+
+func TestTickerDepthStep(t *testing.T) {
+	ticker := NewQueueTicker("STEP")
+	drainTrades(ticker)
+
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(1), Price: d(10.01), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-2", Quantity: d(2), Price: d(10.5), CreateTime: 2, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-3", Quantity: d(3), Price: d(11.2), CreateTime: 3, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(1), Price: d(9.99), CreateTime: 4, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-2", Quantity: d(2), Price: d(9.01), CreateTime: 5, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-3", Quantity: d(4), Price: d(8.9), CreateTime: 6, OrderType: OrderBuy, PriceType: PriceLimit})
+
+	ask, err := ticker.GetAskDepthStep(0, d(1))
+	if err != nil || !reflect.DeepEqual(ask, [][2]string{{"11.00", "3.0000"}, {"12.00", "3.0000"}}) {
+		t.Fatalf("unexpected grouped asks %v %v", ask, err)
+	}
+	bid, _ := ticker.GetBidDepthStep(1, d(1))
+	if !reflect.DeepEqual(bid, [][2]string{{"9.00", "3.0000"}}) {
+		t.Fatalf("unexpected grouped bids %v", bid)
+	}
+	bid, _ = ticker.GetBidDepthStep(0, d(10))
+	if !reflect.DeepEqual(bid, [][2]string{{"0.00", "7.0000"}}) {
+		t.Fatalf("unexpected grouped bids %v", bid)
+	}
+	if _, err := ticker.GetAskDepthStep(0, d(0.5)); err != ErrDepthStep {
+		t.Fatalf("expected ErrDepthStep, got %v", err)
+	}
+	if steps := ticker.DepthSteps(); len(steps) != 4 || !steps[0].Equal(d(0.01)) || !steps[3].Equal(d(10)) {
+		t.Fatalf("unexpected steps %v", steps)
+	}
+}
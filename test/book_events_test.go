@@ -0,0 +1,173 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/Queue"
+)
+
+func TestBookEvents(t *testing.T) {
+	ticker := NewQueueTicker("L3")
+	drainTrades(ticker)
+	events := ticker.BookEvents(100)
+
+	ticker.PushNewOrder(Order{OrderId: "a-1", AccountId: "alice", Quantity: d(1), Price: d(10), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-1", AccountId: "bob", Quantity: d(0.4), Price: d(10), CreateTime: 2, OrderType: OrderBuy, PriceType: PriceLimit})
+
+	want := []struct {
+		eventType BookEventType
+		orderId   string
+		quantity  float64
+	}{
+		{BookAdd, "a-1", 1},
+		{BookAdd, "b-1", 0.4},
+		{BookExecute, "a-1", 0.4},
+		{BookModify, "a-1", 0.6},
+		{BookDelete, "b-1", 0},
+	}
+	for i, w := range want {
+		e := <-events
+		if e.Seq != uint64(i+1) || e.Type != w.eventType || e.OrderId != w.orderId || !e.Quantity.Equal(d(w.quantity)) {
+			t.Fatalf("event %d: got %+v, want %+v", i, e, w)
+		}
+		if e.Type == BookExecute && (e.TakerOrderId != "b-1" || e.TradeId == "" || !e.Price.Equal(d(10))) {
+			t.Fatalf("unexpected execute %+v", e)
+		}
+	}
+
+	s := ticker.BookSnapshot()
+	if s.Seq != 5 || len(s.Bids) != 0 || len(s.Asks) != 1 || s.Asks[0].OrderId != "a-1" || !s.Asks[0].Quantity.Equal(d(0.6)) || s.Asks[0].Priority != 1 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+
+	ticker.CancelOrder(OrderSell, "a-1")
+	if e := <-events; e.Seq != 6 || e.Type != BookDelete || e.OrderId != "a-1" {
+		t.Fatalf("unexpected cancel event %+v", e)
+	}
+}
+
+func TestBBO(t *testing.T) {
+	ticker := NewQueueTicker("BBO")
+	trades := drainTrades(ticker)
+	bbos := ticker.BBOEvents(100)
+
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(1), Price: d(11), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-2", Quantity: d(2), Price: d(10), CreateTime: 2, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-3", Quantity: d(3), Price: d(10), CreateTime: 3, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(1), Price: d(8), CreateTime: 4, OrderType: OrderBuy, PriceType: PriceLimit})
+	// a worse bid leaves the BBO unchanged and sends nothing
+	ticker.PushNewOrder(Order{OrderId: "b-2", Quantity: d(1), Price: d(7), CreateTime: 5, OrderType: OrderBuy, PriceType: PriceLimit})
+
+	for _, want := range []struct{ bid, bidQty, ask, askQty float64 }{
+		{0, 0, 11, 1},
+		{0, 0, 10, 2},
+		{0, 0, 10, 5},
+		{8, 1, 10, 5},
+	} {
+		b := <-bbos
+		if !b.BidPrice.Equal(d(want.bid)) || !b.BidQuantity.Equal(d(want.bidQty)) || !b.AskPrice.Equal(d(want.ask)) || !b.AskQuantity.Equal(d(want.askQty)) {
+			t.Fatalf("got %+v, want %+v", b, want)
+		}
+	}
+	b := ticker.BBO()
+	if b.Seq != 4 || !b.Spread.Equal(d(2)) || !b.Mid.Equal(d(9)) {
+		t.Fatalf("unexpected bbo %+v", b)
+	}
+
+	// a market buy takes the best ask first, oldest order first
+	ticker.PushNewOrder(Order{OrderId: "b-3", Quantity: d(3), CreateTime: 6, OrderType: OrderBuy, PriceType: PriceMarket})
+	for _, orderId := range []string{"a-2", "a-3"} {
+		if trade := <-trades; trade.AskOrderId != orderId || !trade.TradePrice.Equal(d(10)) {
+			t.Fatalf("unexpected trade %+v", trade)
+		}
+	}
+	if b := <-bbos; b.Seq != 5 || !b.AskPrice.Equal(d(10)) || !b.AskQuantity.Equal(d(2)) {
+		t.Fatalf("unexpected bbo after market buy %+v", b)
+	}
+	if ticker.BidLen() != 2 {
+		t.Fatalf("market order should not rest, %d bids", ticker.BidLen())
+	}
+}
+
+func TestSweepWithBusyConsumer(t *testing.T) {
+	ticker := NewQueueTicker("SWEEP")
+	go func() {
+		for range ticker.ChCancelResult {
+		}
+	}()
+	bbos := ticker.BBOEvents(1)
+
+	for i := 0; i < 30; i++ {
+		ticker.PushNewOrder(Order{OrderId: fmt.Sprintf("a-%d", i), Quantity: d(1), Price: d(float64(10 + i)), CreateTime: int64(i), OrderType: OrderSell, PriceType: PriceLimit})
+	}
+	// more fills than ChTradeResult holds, read by a consumer that looks at
+	// the BBO for each, as the market data feed does
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 30; i++ {
+			<-ticker.ChTradeResult
+			ticker.BBO()
+		}
+		done <- true
+	}()
+	go func() {
+		for range bbos {
+		}
+	}()
+	go ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(30), CreateTime: 30, OrderType: OrderBuy, PriceType: PriceMarket})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sweep deadlocked")
+	}
+}
+
+func TestBBOExactQuantity(t *testing.T) {
+	ticker := NewQueueTicker("EXACT")
+	drainTrades(ticker)
+
+	// both prices fall in the same 2-decimal depth level
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(1), Price: d(10.001), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-2", Quantity: d(2), Price: d(10.004), CreateTime: 2, OrderType: OrderSell, PriceType: PriceLimit})
+
+	if b := ticker.BBO(); !b.AskPrice.Equal(d(10.001)) || !b.AskQuantity.Equal(d(1)) {
+		t.Fatalf("unexpected bbo %+v", b)
+	}
+}
+
+func TestFillsBeforeCancel(t *testing.T) {
+	ticker := NewQueueTicker("ORDERED")
+	for i := 0; i < 15; i++ {
+		ticker.PushNewOrder(Order{OrderId: fmt.Sprintf("a-%d", i), Quantity: d(1), Price: d(10), CreateTime: int64(i), OrderType: OrderSell, PriceType: PriceLimit})
+	}
+	// the market buy fills 15 and cancels the rest
+	go ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(20), CreateTime: 15, OrderType: OrderBuy, PriceType: PriceMarket})
+
+	settled := 0
+	for {
+		select {
+		case <-ticker.ChTradeResult:
+			settled++
+		case orderId := <-ticker.ChCancelResult:
+			// as watchTradeLog does, settle what was sent before the cancel
+			for pending := true; pending; {
+				select {
+				case <-ticker.ChTradeResult:
+					settled++
+				default:
+					pending = false
+				}
+			}
+			if orderId != "b-1" || settled != 15 {
+				t.Fatalf("cancel of %s after %d fills, want b-1 after 15", orderId, settled)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("no cancel")
+		}
+	}
+}
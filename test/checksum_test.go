@@ -0,0 +1,61 @@
+package test
+
+import (
+	"hash/crc32"
+	"testing"
+
+	"github.com/User/internal/pkg/Depth"
+	"github.com/User/internal/pkg/Order"
+	"github.com/User/internal/pkg/Queue"
+	"github.com/User/pkg/Checksum"
+)
+
+func TestChecksumCanonical(t *testing.T) {
+	bids := [][2]string{{"9.00", "1.0000"}, {"8.00", "2.0000"}}
+	asks := [][2]string{{"10.00", "3.0000"}}
+
+	canonical := Checksum.Canonical(bids, asks)
+	if canonical != "9.00:1.0000:10.00:3.0000:8.00:2.0000" {
+		t.Fatalf("unexpected canonical string %q", canonical)
+	}
+	if !Checksum.Verify(bids, asks, crc32.ChecksumIEEE([]byte(canonical))) {
+		t.Fatal("checksum does not verify")
+	}
+	if Checksum.Verify(bids[:1], asks, Checksum.Compute(bids, asks)) {
+		t.Fatal("checksum of a different book verifies")
+	}
+	if Checksum.Compute(nil, nil) != 0 {
+		t.Fatal("empty book should have a zero checksum")
+	}
+}
+
+func TestChecksumFollowsUpdates(t *testing.T) {
+	ticker := Queue.NewQueueTicker("AA")
+	drainTrades(ticker)
+	events := ticker.BookEvents(100)
+	book := Depth.NewBook("AA")
+	book.Load(ticker.BookSnapshot())
+
+	ticker.PushNewOrder(Order.Order{OrderId: "b-1", Quantity: d(1), Price: d(9), CreateTime: 1, OrderType: Order.OrderBuy, PriceType: Order.PriceLimit})
+	ticker.PushNewOrder(Order.Order{OrderId: "a-1", Quantity: d(1), Price: d(10), CreateTime: 2, OrderType: Order.OrderSell, PriceType: Order.PriceLimit})
+	ticker.PushNewOrder(Order.Order{OrderId: "a-2", Quantity: d(1), Price: d(11), CreateTime: 3, OrderType: Order.OrderSell, PriceType: Order.PriceLimit})
+	followBook(ticker, events, book)
+	book.Flush()
+	snapshot := book.Snapshot(0)
+
+	local := Checksum.NewBook(snapshot.Bids, snapshot.Asks)
+	if !local.Verify(snapshot.Checksum) {
+		t.Fatal("snapshot checksum does not verify")
+	}
+
+	ticker.PushNewOrder(Order.Order{OrderId: "b-2", Quantity: d(2), Price: d(9.5), CreateTime: 4, OrderType: Order.OrderBuy, PriceType: Order.PriceLimit})
+	ticker.CancelOrder(Order.OrderSell, "a-1")
+	ticker.PushNewOrder(Order.Order{OrderId: "a-3", Quantity: d(3), Price: d(11), CreateTime: 5, OrderType: Order.OrderSell, PriceType: Order.PriceLimit})
+	followBook(ticker, events, book)
+	u, _ := book.Flush()
+	local.Apply(u.Bids, u.Asks)
+	if !local.Verify(u.Checksum) {
+		bids, asks := local.Levels(0)
+		t.Fatalf("update checksum does not verify, local book %v %v", bids, asks)
+	}
+}
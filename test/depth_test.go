@@ -0,0 +1,110 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/User/internal/pkg/Depth"
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/Queue"
+	"github.com/User/pkg/Checksum"
+)
+
+// followBook applies the ticker's book events to book up to its current
+// book seq.
+func followBook(ticker *QueueTicker, events <-chan BookEvent, book *Book) {
+	for seq := ticker.BookSeq(); seq > 0; {
+		e := <-events
+		book.Apply(e)
+		if e.Seq >= seq {
+			return
+		}
+	}
+}
+
+func TestDepthUpdates(t *testing.T) {
+	ticker := NewQueueTicker("AA")
+	trades := drainTrades(ticker)
+
+	// the book starts from orders that rested before the feed
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(1), Price: d(10), CreateTime: 1, OrderType: OrderBuy, PriceType: PriceLimit})
+	events := ticker.BookEvents(100)
+	book := NewBook("AA")
+	book.Load(ticker.BookSnapshot())
+	u, ok := book.Flush()
+	if !ok || u.FirstUpdateId != 1 || u.LastUpdateId != 1 || !reflect.DeepEqual(u.Bids, [][2]string{{"10.00", "1.0000"}}) {
+		t.Fatalf("unexpected first update %+v", u)
+	}
+	if _, ok := book.Flush(); ok {
+		t.Fatal("unchanged book should not produce an update")
+	}
+
+	ticker.PushNewOrder(Order{OrderId: "b-2", Quantity: d(2), Price: d(9), CreateTime: 2, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(3), Price: d(11), CreateTime: 3, OrderType: OrderSell, PriceType: PriceLimit})
+	followBook(ticker, events, book)
+	u, ok = book.Flush()
+	if !ok || u.FirstUpdateId != 2 || u.LastUpdateId != 3 {
+		t.Fatalf("unexpected ids %+v", u)
+	}
+	if !reflect.DeepEqual(u.Bids, [][2]string{{"9.00", "2.0000"}}) || !reflect.DeepEqual(u.Asks, [][2]string{{"11.00", "3.0000"}}) {
+		t.Fatalf("unexpected update %+v", u)
+	}
+
+	// the sell fills b-1 and rests the rest of its quantity: the 10.00 bid
+	// goes and an ask appears at the same price
+	ticker.PushNewOrder(Order{OrderId: "a-2", Quantity: d(1.5), Price: d(10), CreateTime: 4, OrderType: OrderSell, PriceType: PriceLimit})
+	<-trades
+	// an order added and canceled between two flushes changes nothing
+	ticker.PushNewOrder(Order{OrderId: "a-3", Quantity: d(1), Price: d(12), CreateTime: 5, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.CancelOrder(OrderSell, "a-3")
+	followBook(ticker, events, book)
+	u, ok = book.Flush()
+	if !ok || u.FirstUpdateId != 4 || u.LastUpdateId != 5 {
+		t.Fatalf("unexpected ids %+v", u)
+	}
+	if !reflect.DeepEqual(u.Bids, [][2]string{{"10.00", "0"}}) || !reflect.DeepEqual(u.Asks, [][2]string{{"10.00", "0.5000"}}) {
+		t.Fatalf("unexpected update %+v", u)
+	}
+
+	s := book.Snapshot(0)
+	if s.LastUpdateId != 5 || !reflect.DeepEqual(s.Bids, ticker.GetBidDepth(0)) || !reflect.DeepEqual(s.Asks, ticker.GetAskDepth(0)) {
+		t.Fatalf("snapshot %+v differs from the engine depth %v %v", s, ticker.GetBidDepth(0), ticker.GetAskDepth(0))
+	}
+	if s := book.Snapshot(1); len(s.Asks) != 1 || s.Asks[0][0] != "10.00" {
+		t.Fatalf("unexpected limited snapshot %+v", s)
+	}
+}
+
+func TestDepthTinyLevel(t *testing.T) {
+	ticker := NewQueueTicker("AA")
+	drainTrades(ticker)
+	events := ticker.BookEvents(100)
+	book := NewBook("AA")
+	book.Load(ticker.BookSnapshot())
+
+	// a level below the shown precision would read as "0.0000", which a
+	// client takes for a removed level: it is left out everywhere
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(0.00001), Price: d(10), CreateTime: 1, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-2", Quantity: d(1), Price: d(9), CreateTime: 2, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-3", Quantity: d(0.00002), Price: d(8.99), CreateTime: 3, OrderType: OrderBuy, PriceType: PriceLimit})
+	followBook(ticker, events, book)
+	u, ok := book.Flush()
+	if !ok || !reflect.DeepEqual(u.Bids, [][2]string{{"9.00", "1.0000"}}) {
+		t.Fatalf("unexpected update %+v", u)
+	}
+	if bids := ticker.GetBidDepth(0); !reflect.DeepEqual(bids, u.Bids) {
+		t.Fatalf("unexpected engine depth %v", bids)
+	}
+	if bids := ticker.GetBidDepth(1); !reflect.DeepEqual(bids, u.Bids) {
+		t.Fatalf("unexpected limited engine depth %v", bids)
+	}
+	if bids, _ := ticker.GetBidDepthStep(0, d(1)); !reflect.DeepEqual(bids, u.Bids) {
+		t.Fatalf("unexpected grouped depth %v", bids)
+	}
+
+	client := Checksum.NewBook(nil, nil)
+	client.Apply(u.Bids, u.Asks)
+	if !client.Verify(book.Snapshot(0).Checksum) || !client.Verify(Checksum.Compute(ticker.GetBidDepth(10), ticker.GetAskDepth(10))) {
+		t.Fatal("client book does not match the checksums")
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/User/internal/pkg/Journal"
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/Queue"
+)
+
+func drainTrades(ticker *QueueTicker) chan TradeResult {
+	trades := make(chan TradeResult, 100)
+	go func() {
+		for trade := range ticker.ChTradeResult {
+			trades <- trade
+		}
+	}()
+	go func() {
+		for range ticker.ChCancelResult {
+		}
+	}()
+	return trades
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.journal")
+	journal, err := Open(path, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticker := NewQueueTicker("J")
+	ticker.SetJournal(journal)
+	trades := drainTrades(ticker)
+
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(10), Price: d(10), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-2", Quantity: d(10), Price: d(11), CreateTime: 2, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-3", Quantity: d(10), Price: d(12), CreateTime: 3, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(4), Price: d(10), CreateTime: 4, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.CancelOrder(OrderSell, "a-3")
+	ticker.AmendOrder(Order{OrderId: "a-2", Quantity: d(5), Price: d(10), CreateTime: 5, OrderType: OrderSell})
+	ticker.PushNewOrder(Order{OrderId: "b-2", Quantity: d(8), Price: d(10), CreateTime: 6, OrderType: OrderBuy, PriceType: PriceLimit})
+
+	want := []string{}
+	for i := 0; i < 3; i++ {
+		want = append(want, (<-trades).TradeId)
+	}
+	journal.Close()
+
+	// a torn record at the end must be ignored and truncated away
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write([]byte{0, 0, 0, 42, 1, 2})
+	file.Close()
+
+	journal, err = Open(path, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if journal.Seq() != 7 {
+		t.Fatalf("expected 7 journaled commands, got %d", journal.Seq())
+	}
+
+	replayed := NewQueueTicker("J")
+	if err := replayed.Replay(journal, 0); err != nil {
+		t.Fatal(err)
+	}
+	replayed.SetJournal(journal)
+	if replayed.AskLen() != ticker.AskLen() || replayed.BidLen() != ticker.BidLen() {
+		t.Fatalf("replayed book %d/%d, want %d/%d", replayed.AskLen(), replayed.BidLen(), ticker.AskLen(), ticker.BidLen())
+	}
+	if !replayed.LatestPrice().Equal(ticker.LatestPrice()) {
+		t.Fatalf("replayed latest price %s, want %s", replayed.LatestPrice(), ticker.LatestPrice())
+	}
+
+	next := drainTrades(replayed)
+	replayed.PushNewOrder(Order{OrderId: "b-3", Quantity: d(1), Price: d(10), CreateTime: 7, OrderType: OrderBuy, PriceType: PriceLimit})
+	if trade := <-next; trade.TradeId != "J-4" {
+		t.Fatalf("expected trade J-4 after %v, got %s", want, trade.TradeId)
+	}
+}
+
+func TestJournalCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.journal")
+	journal, err := Open(path, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		journal.Append(Command{Type: CommandStatus, Status: "trading"})
+	}
+	journal.Close()
+	data, _ := os.ReadFile(path)
+
+	// a zero-filled tail is what a crash leaves after the last write
+	os.WriteFile(path, append(append([]byte{}, data...), make([]byte, 20)...), 0644)
+	journal, err = Open(path, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.Seq() != 3 {
+		t.Fatalf("expected 3 commands, got %d", journal.Seq())
+	}
+	journal.Close()
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Fatalf("expected the zero tail to be truncated, size %d", info.Size())
+	}
+
+	// a corrupt record with valid ones after it is refused, not truncated
+	corrupt := append([]byte{}, data...)
+	corrupt[len(data)/2] ^= 0xff
+	os.WriteFile(path, corrupt, 0644)
+	if _, err := Open(path, Options{Sync: SyncNever}); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Fatalf("a corrupt journal must be left as it is, size %d", info.Size())
+	}
+
+	// a corrupt last record is a torn write
+	corrupt = append([]byte{}, data...)
+	corrupt[len(data)-2] ^= 0xff
+	os.WriteFile(path, corrupt, 0644)
+	journal, err = Open(path, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if journal.Seq() != 2 {
+		t.Fatalf("expected 2 commands, got %d", journal.Seq())
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"testing"
+
+	. "github.com/User/internal/pkg/Kline"
+)
+
+func TestKlineAggregate(t *testing.T) {
+	agg := NewAggregator()
+	agg.Add("AA", d(10), d(1), d(10), 60)
+	agg.Add("AA", d(12), d(2), d(24), 70)
+	agg.Add("AA", d(9), d(1), d(9), 119)
+	updated := agg.Add("AA", d(11), d(1), d(11), 300)
+	if len(updated) != len(Intervals) {
+		t.Fatalf("updated %d candles, want %d", len(updated), len(Intervals))
+	}
+
+	interval, _ := ParseInterval("1m")
+	candles := agg.Query("AA", interval, 0, 359, 0)
+	if len(candles) != 5 {
+		t.Fatalf("got %d candles, want 5", len(candles))
+	}
+	c := candles[0]
+	if c.OpenTime != 60 || c.CloseTime != 119 || !c.Open.Equal(d(10)) || !c.High.Equal(d(12)) ||
+		!c.Low.Equal(d(9)) || !c.Close.Equal(d(9)) || !c.Volume.Equal(d(4)) || !c.QuoteVolume.Equal(d(43)) || c.Trades != 3 {
+		t.Fatalf("unexpected first candle %+v", c)
+	}
+
+	// 120..240 had no trades and carry the previous close
+	for _, c := range candles[1:4] {
+		if !c.Open.Equal(d(9)) || !c.Close.Equal(d(9)) || !c.Volume.IsZero() || c.Trades != 0 {
+			t.Fatalf("unexpected filled candle %+v", c)
+		}
+	}
+	if !candles[4].Open.Equal(d(11)) || candles[4].OpenTime != 300 {
+		t.Fatalf("unexpected last candle %+v", candles[4])
+	}
+
+	// the range is clipped to start and limit
+	candles = agg.Query("AA", interval, 130, 359, 2)
+	if len(candles) != 2 || candles[0].OpenTime != 120 || !candles[0].Close.Equal(d(9)) {
+		t.Fatalf("unexpected range %+v", candles)
+	}
+
+	interval, _ = ParseInterval("5m")
+	candles = agg.Query("AA", interval, 0, 359, 0)
+	if len(candles) != 2 || !candles[0].Close.Equal(d(9)) || !candles[1].Open.Equal(d(11)) {
+		t.Fatalf("unexpected 5m candles %+v", candles)
+	}
+
+	if _, ok := ParseInterval("2m"); ok {
+		t.Fatal("2m should not be a valid interval")
+	}
+}
+
+// TestKlineQueryWhileAdding is meant for -race: Add updates the stored
+// candles in place while Query reads them.
+func TestKlineQueryWhileAdding(t *testing.T) {
+	agg := NewAggregator()
+	interval, _ := ParseInterval("1m")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(0); i < 2000; i++ {
+			agg.Add("AA", d(10), d(1), d(10), i)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if c := agg.Query("AA", interval, 0, 1999, 0); len(c) != 34 || c[33].Trades != 20 {
+				t.Fatalf("unexpected candles %d", len(c))
+			}
+			return
+		default:
+			agg.Query("AA", interval, 0, 1999, 0)
+		}
+	}
+}
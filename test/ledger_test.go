@@ -0,0 +1,82 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/User/internal/pkg/Account"
+	. "github.com/User/internal/pkg/Ledger"
+	. "github.com/User/internal/pkg/Order"
+)
+
+func TestLedgerConservation(t *testing.T) {
+	accounts := NewAccounts()
+	accounts.SetFeeRate(d(0.001))
+	accounts.Deposit("seller", "AA", d(10))
+	accounts.Deposit("buyer", "USDT", d(100))
+	accounts.Hold(Order{OrderId: "a-1", AccountId: "seller", Quantity: d(10), Price: d(5), OrderType: OrderSell}, "AA", "USDT")
+	accounts.Hold(Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(10), Price: d(5), OrderType: OrderBuy}, "AA", "USDT")
+
+	ledger := NewLedger()
+	for i, qty := range []float64{3, 7} {
+		s, err := accounts.Settle("a-1", "b-1", d(qty), d(qty*5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if postings := ledger.Record(fmt.Sprintf("T-%d", i+1), 1, s); len(postings) != 4 {
+			t.Fatalf("expected 4 postings, got %d", len(postings))
+		}
+	}
+
+	if err := ledger.Check(accounts); err != nil {
+		t.Fatal(err)
+	}
+	if fees := ledger.Query(FeeAccount, 0, 0); len(fees) != 4 {
+		t.Fatalf("expected 4 fee postings, got %d", len(fees))
+	}
+
+	net := ledger.Balances()
+	if !net["buyer"]["AA"].Equal(d(9.99)) || !net[FeeAccount]["USDT"].Equal(d(0.05)) {
+		t.Fatalf("unexpected ledger balances %+v", net)
+	}
+	total, deposited := accounts.Totals()
+	for asset, amount := range total {
+		if !amount.Equal(deposited[asset]) {
+			t.Fatalf("asset %s: balances %s, deposited %s", asset, amount, deposited[asset])
+		}
+	}
+}
+
+func TestLedgerCheckFails(t *testing.T) {
+	setup := func() (*Accounts, *Ledger) {
+		accounts := NewAccounts()
+		accounts.Deposit("seller", "AA", d(10))
+		accounts.Deposit("buyer", "USDT", d(100))
+		accounts.Hold(Order{OrderId: "a-1", AccountId: "seller", Quantity: d(10), Price: d(5), OrderType: OrderSell}, "AA", "USDT")
+		accounts.Hold(Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(10), Price: d(5), OrderType: OrderBuy}, "AA", "USDT")
+		return accounts, NewLedger()
+	}
+
+	// a settlement that never reached the ledger
+	accounts, ledger := setup()
+	if _, err := accounts.Settle("a-1", "b-1", d(3), d(15)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Check(accounts); err == nil {
+		t.Fatal("expected an unrecorded settlement to fail the check")
+	}
+
+	// postings of a trade that was never settled
+	accounts, ledger = setup()
+	ledger.Record("T-1", 1, Settlement{Seller: "seller", Buyer: "buyer", Base: "AA", Quote: "USDT", Quantity: d(3), Amount: d(15)})
+	if err := ledger.Check(accounts); err == nil {
+		t.Fatal("expected an unsettled trade to fail the check")
+	}
+
+	// postings for an account that has no balance at all
+	accounts, ledger = setup()
+	ledger.Record("T-1", 1, Settlement{Seller: "seller", Buyer: "nobody", Base: "AA", Quote: "USDT", Quantity: d(3), Amount: d(15)})
+	if err := ledger.Check(accounts); err == nil {
+		t.Fatal("expected postings of an unknown account to fail the check")
+	}
+}
@@ -0,0 +1,41 @@
+package test
+
+import (
+	"testing"
+
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/OrderStore"
+)
+
+func TestOrderStoreLifecycle(t *testing.T) {
+	store := NewStore()
+	store.Add(NewRecord("AA", Order{OrderId: "b-1", AccountId: "x", Quantity: d(10), Price: d(10), CreateTime: 1, OrderType: OrderBuy}))
+	store.Add(NewRecord("AA", Order{OrderId: "b-2", AccountId: "x", Quantity: d(1), Price: d(10), CreateTime: 2, OrderType: OrderBuy}))
+	store.Add(NewRecord("AA", Order{OrderId: "a-1", AccountId: "y", Quantity: d(1), Price: d(10), CreateTime: 3, OrderType: OrderSell}))
+
+	store.Fill("b-1", d(4), d(10), 4)
+	store.Fill("b-1", d(2), d(13), 5)
+	store.Close("b-1", StatusCanceled, 6)
+	store.Fill("b-2", d(1), d(10), 7)
+	store.Close("b-2", StatusCanceled, 8)
+
+	r, _ := store.Get("b-1")
+	if r.Status != StatusCanceled || !r.FilledQuantity.Equal(d(6)) || !r.RemainingQuantity.Equal(d(4)) || !r.AveragePrice.Equal(d(11)) {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if r, _ := store.Get("b-2"); r.Status != StatusFilled {
+		t.Fatalf("a filled order cannot be canceled, got %s", r.Status)
+	}
+
+	page, _ := store.Query(Query{AccountId: "x", Limit: 1})
+	if len(page.Orders) != 1 || page.Orders[0].OrderId != "b-2" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page, _ = store.Query(Query{AccountId: "x", Limit: 1, Cursor: page.NextCursor})
+	if len(page.Orders) != 1 || page.Orders[0].OrderId != "b-1" || page.NextCursor != "" {
+		t.Fatalf("unexpected second page %+v", page)
+	}
+	if page, _ := store.Query(Query{Status: StatusNew}); len(page.Orders) != 1 {
+		t.Fatalf("expected one new order, got %d", len(page.Orders))
+	}
+}
@@ -51,3 +51,34 @@ func TestTicker(t *testing.T) {
 	testTicker.PushNewOrder(Order{OrderId: "1", Quantity: d(10), Price: d(10), CreateTime: 1111111, OrderType: OrderBuy, PriceType: PriceLimit})
 	fmt.Printf("%+v\n", testTicker.BidLen())
 }
+
+func TestTickerCrossingLimitOrders(t *testing.T) {
+	ticker := NewQueueTicker("X")
+	trades := drainTrades(ticker)
+
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(1), Price: d(10), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "a-2", Quantity: d(1), Price: d(9), CreateTime: 2, OrderType: OrderSell, PriceType: PriceLimit})
+
+	// a bid above the best ask takes the asks it crosses, best price first,
+	// at their prices, and rests the rest
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(3), Price: d(11), CreateTime: 3, OrderType: OrderBuy, PriceType: PriceLimit})
+	for _, want := range []string{"a-2 9", "a-1 10"} {
+		trade := <-trades
+		if got := trade.AskOrderId + " " + trade.TradePrice.String(); got != want {
+			t.Fatalf("expected trade %s, got %s", want, got)
+		}
+	}
+	if ticker.AskLen() != 0 || ticker.BidLen() != 1 {
+		t.Fatalf("unexpected book %d/%d", ticker.AskLen(), ticker.BidLen())
+	}
+
+	// an ask below the best bid trades at the bid's price
+	ticker.PushNewOrder(Order{OrderId: "a-3", Quantity: d(2), Price: d(10.5), CreateTime: 4, OrderType: OrderSell, PriceType: PriceLimit})
+	if trade := <-trades; trade.BidOrderId != "b-1" || !trade.TradePrice.Equal(d(11)) || !trade.TradeQuantity.Equal(d(1)) {
+		t.Fatalf("unexpected trade %+v", trade)
+	}
+	bbo := ticker.BBO()
+	if !bbo.AskPrice.Equal(d(10.5)) || !bbo.BidPrice.IsZero() {
+		t.Fatalf("unexpected bbo %+v", bbo)
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"testing"
+
+	. "github.com/User/internal/pkg/Account"
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/Risk"
+)
+
+func TestRiskChecks(t *testing.T) {
+	checker := NewChecker(DefaultLimits())
+	limits := checker.Limits()
+	limits.MaxOrderNotional = d(1000)
+	limits.MaxOpenOrders = 2
+	limits.MaxPosition = d(50)
+	limits.PriceCollar = d(0.1)
+	if err := checker.SetLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := Context{LastPrice: d(10), OpenOrders: 1, Position: d(40)}
+	order := Order{OrderId: "b-1", Quantity: d(5), Price: d(10), OrderType: OrderBuy, PriceType: PriceLimit}
+	if rejection := checker.Check(order, ctx); rejection != nil {
+		t.Fatal(rejection)
+	}
+
+	cases := []struct {
+		order Order
+		ctx   Context
+		code  Code
+	}{
+		{Order{Quantity: d(0), Price: d(10), PriceType: PriceLimit}, ctx, CodeInvalidQuantity},
+		{Order{Quantity: d(200000000), Price: d(10), PriceType: PriceLimit}, ctx, CodeMaxQuantity},
+		{Order{Quantity: d(1), Price: d(12), PriceType: PriceLimit}, ctx, CodePriceCollar},
+		{Order{Quantity: d(200), Price: d(10), OrderType: OrderSell, PriceType: PriceLimit}, ctx, CodeMaxNotional},
+		{order, Context{LastPrice: d(10), OpenOrders: 2}, CodeMaxOpenOrders},
+		{Order{Quantity: d(20), Price: d(10), OrderType: OrderBuy, PriceType: PriceLimit}, ctx, CodeMaxPosition},
+	}
+	for i, c := range cases {
+		rejection := checker.Check(c.order, c.ctx)
+		if rejection == nil || rejection.Code != c.code {
+			t.Fatalf("case %d: expected %s, got %v", i, c.code, rejection)
+		}
+	}
+}
+
+// TestRiskPositionCountsOpenBids places two bids that each fit under
+// MaxPosition but not together.
+func TestRiskPositionCountsOpenBids(t *testing.T) {
+	checker := NewChecker(DefaultLimits())
+	limits := checker.Limits()
+	limits.MaxPosition = d(10)
+	checker.SetLimits(limits)
+
+	accounts := NewAccounts()
+	accounts.Deposit("buyer", "USDT", d(1000))
+	position := func() Context {
+		ctx := Context{Position: accounts.Available("buyer", "AA")}
+		for _, quantity := range accounts.OpenBids("buyer", "AA", "USDT") {
+			ctx.Position = ctx.Position.Add(quantity)
+		}
+		return ctx
+	}
+
+	first := Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(8), Price: d(10), OrderType: OrderBuy, PriceType: PriceLimit}
+	if rejection := checker.Check(first, position()); rejection != nil {
+		t.Fatal(rejection)
+	}
+	if err := accounts.Hold(first, "AA", "USDT"); err != nil {
+		t.Fatal(err)
+	}
+
+	second := Order{OrderId: "b-2", AccountId: "buyer", Quantity: d(8), Price: d(10), OrderType: OrderBuy, PriceType: PriceLimit}
+	if rejection := checker.Check(second, position()); rejection == nil || rejection.Code != CodeMaxPosition {
+		t.Fatalf("expected %s, got %v", CodeMaxPosition, rejection)
+	}
+}
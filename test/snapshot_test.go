@@ -0,0 +1,182 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/User/internal/pkg/Account"
+	. "github.com/User/internal/pkg/Journal"
+	. "github.com/User/internal/pkg/Ledger"
+	. "github.com/User/internal/pkg/Order"
+	. "github.com/User/internal/pkg/Queue"
+	. "github.com/User/internal/pkg/Snapshot"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := Open(filepath.Join(dir, "test.journal"), Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	ticker := NewQueueTicker("S")
+	ticker.SetJournal(journal)
+	drainTrades(ticker)
+
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(10), Price: d(10), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-1", Quantity: d(3), Price: d(10), CreateTime: 2, OrderType: OrderBuy, PriceType: PriceLimit})
+	if _, err := Write(dir, ticker.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	ticker.PushNewOrder(Order{OrderId: "b-2", Quantity: d(2), Price: d(9), CreateTime: 3, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.SetStatus(StatusHalted)
+
+	// a corrupt newer snapshot must be skipped
+	os.WriteFile(filepath.Join(dir, FileName("S", 99)), []byte(`{"version":1}`), 0644)
+
+	s, _, ok := Latest(dir, "S")
+	if !ok || s.JournalSeq != 2 {
+		t.Fatalf("expected the snapshot at seq 2, got %v %d", ok, s.JournalSeq)
+	}
+
+	restored := NewQueueTicker("S")
+	if err := restored.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Replay(journal, s.JournalSeq); err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := ticker.Snapshot(), restored.Snapshot()
+	want.CreateTime, got.CreateTime = 0, 0
+	wantJson, _ := json.Marshal(want)
+	gotJson, _ := json.Marshal(got)
+	if string(wantJson) != string(gotJson) {
+		t.Fatalf("restored state %s, want %s", gotJson, wantJson)
+	}
+	if restored.Status() != StatusHalted {
+		t.Fatalf("expected halted status, got %s", restored.Status())
+	}
+
+	// feed sequence numbers continue as if the whole journal was replayed
+	replayed := NewQueueTicker("S")
+	if err := replayed.Replay(journal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if restored.BookSeq() != replayed.BookSeq() || restored.BookSeq() != ticker.BookSeq() {
+		t.Fatalf("book seq %d, replayed %d, live %d", restored.BookSeq(), replayed.BookSeq(), ticker.BookSeq())
+	}
+	if restored.BBO().Seq != replayed.BBO().Seq || restored.BBO().Seq != ticker.BBO().Seq {
+		t.Fatalf("bbo seq %d, replayed %d, live %d", restored.BBO().Seq, replayed.BBO().Seq, ticker.BBO().Seq)
+	}
+}
+
+// fundsState is what a ticker's funds restore to, leaving out posting times,
+// which are the time trades were settled.
+func fundsState(accounts *Accounts, ledger *Ledger) string {
+	postings := ledger.Postings()
+	for i := range postings {
+		postings[i].Time = 0
+	}
+	data, _ := json.Marshal([]interface{}{accounts.State(), postings})
+	return string(data)
+}
+
+func TestSnapshotRestoreFunds(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := Open(filepath.Join(dir, "test.journal"), Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	accounts, ledger := NewAccounts(), NewLedger()
+	ticker := NewQueueTicker("H")
+	ticker.SetFunds(accountFunds{accounts: accounts, ledger: ledger})
+	ticker.SetJournal(journal)
+	drainTrades(ticker)
+
+	ticker.SetFeeRate(d(0.001))
+	ticker.Deposit("seller", "AA", d(10))
+	ticker.Deposit("buyer", "USDT", d(100))
+	ticker.PushNewOrder(Order{OrderId: "a-1", AccountId: "seller", Quantity: d(10), Price: d(5), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-1", AccountId: "buyer", Quantity: d(4), Price: d(5), CreateTime: 2, OrderType: OrderBuy, PriceType: PriceLimit})
+	if _, err := Write(dir, ticker.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	// a deposit, a refused order, a trade and a cancel after the snapshot
+	ticker.Deposit("buyer", "USDT", d(10))
+	if err := ticker.PushNewOrder(Order{OrderId: "b-2", AccountId: "buyer", Quantity: d(100), Price: d(5), CreateTime: 3, OrderType: OrderBuy, PriceType: PriceLimit}); err != ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+	ticker.PushNewOrder(Order{OrderId: "b-3", AccountId: "buyer", Quantity: d(2), Price: d(5), CreateTime: 4, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.PushNewOrder(Order{OrderId: "b-4", AccountId: "buyer", Quantity: d(1), Price: d(4), CreateTime: 5, OrderType: OrderBuy, PriceType: PriceLimit})
+	ticker.CancelOrder(OrderBuy, "b-4")
+	seq := journal.Seq()
+
+	s, _, _ := Latest(dir, "H")
+	if len(s.Accounts.Holds) != 1 || len(s.Postings) != 4 {
+		t.Fatalf("snapshot has %d holds and %d postings", len(s.Accounts.Holds), len(s.Postings))
+	}
+	restoredAccounts, restoredLedger := NewAccounts(), NewLedger()
+	restored := NewQueueTicker("H")
+	restored.SetFunds(accountFunds{accounts: restoredAccounts, ledger: restoredLedger})
+	if err := restored.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Replay(journal, s.JournalSeq); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := fundsState(accounts, ledger), fundsState(restoredAccounts, restoredLedger); got != want {
+		t.Fatalf("restored funds %s, want %s", got, want)
+	}
+	if err := restoredLedger.Check(restoredAccounts); err != nil {
+		t.Fatal(err)
+	}
+	if journal.Seq() != seq {
+		t.Fatalf("restoring journaled %d commands", journal.Seq()-seq)
+	}
+
+	replayedAccounts, replayedLedger := NewAccounts(), NewLedger()
+	replayed := NewQueueTicker("H")
+	replayed.SetFunds(accountFunds{accounts: replayedAccounts, ledger: replayedLedger})
+	if err := replayed.Replay(journal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := fundsState(accounts, ledger), fundsState(replayedAccounts, replayedLedger); got != want {
+		t.Fatalf("replayed funds %s, want %s", got, want)
+	}
+
+	// the restored orders are still covered by their holds
+	restored.SetJournal(journal)
+	trades := drainTrades(restored)
+	if err := restored.PushNewOrder(Order{OrderId: "b-5", AccountId: "buyer", Quantity: d(4), Price: d(5), CreateTime: 6, OrderType: OrderBuy, PriceType: PriceLimit}); err != nil {
+		t.Fatal(err)
+	}
+	<-trades
+	if err := restoredLedger.Check(restoredAccounts); err != nil {
+		t.Fatalf("settle after restore: %v", err)
+	}
+	if seller := restoredAccounts.Balances("seller"); !seller["AA"].Held.IsZero() || !seller["AA"].Available.IsZero() {
+		t.Fatalf("unexpected seller balances %+v", seller)
+	}
+}
+
+func TestSnapshotSeqWithoutJournal(t *testing.T) {
+	ticker := NewQueueTicker("N")
+	drainTrades(ticker)
+
+	// commands are numbered without a journal too, so periodic snapshots
+	// see the state change
+	ticker.PushNewOrder(Order{OrderId: "a-1", Quantity: d(1), Price: d(10), CreateTime: 1, OrderType: OrderSell, PriceType: PriceLimit})
+	ticker.CancelOrder(OrderSell, "a-1")
+	if s := ticker.Snapshot(); s.JournalSeq != 2 {
+		t.Fatalf("expected journal seq 2, got %d", s.JournalSeq)
+	}
+	if err := ticker.SyncJournal(); err != nil {
+		t.Fatal(err)
+	}
+}
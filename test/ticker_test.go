@@ -0,0 +1,38 @@
+package test
+
+import (
+	"testing"
+
+	. "github.com/User/internal/pkg/Ticker"
+)
+
+func TestTickerRollingWindow(t *testing.T) {
+	r := NewRollingWindow("AA", 100)
+	r.Add(d(10), d(1), d(10), 1)
+	r.Add(d(15), d(1), d(15), 20)
+	r.Add(d(8), d(2), d(16), 50)
+	r.Add(d(12), d(1), d(12), 90)
+
+	s := r.Stats(90)
+	if !s.Open.Equal(d(10)) || !s.High.Equal(d(15)) || !s.Low.Equal(d(8)) || !s.Last.Equal(d(12)) {
+		t.Fatalf("unexpected prices %+v", s)
+	}
+	if !s.Volume.Equal(d(5)) || !s.QuoteVolume.Equal(d(53)) || s.Trades != 4 {
+		t.Fatalf("unexpected volume %+v", s)
+	}
+	if !s.Change.Equal(d(2)) || !s.ChangePercent.Equal(d(20)) || !s.WeightedAvgPrice.Equal(d(10.6)) {
+		t.Fatalf("unexpected change %+v", s)
+	}
+
+	// the first two trades leave the window, taking the high with them
+	s = r.Stats(125)
+	if !s.Open.Equal(d(8)) || !s.High.Equal(d(12)) || !s.Low.Equal(d(8)) || s.Trades != 2 || !s.Volume.Equal(d(3)) {
+		t.Fatalf("unexpected stats after eviction %+v", s)
+	}
+
+	// an empty window keeps the last price with zero volume
+	s = r.Stats(500)
+	if !s.Open.Equal(d(12)) || !s.High.Equal(d(12)) || !s.Last.Equal(d(12)) || s.Trades != 0 || !s.Volume.IsZero() || !s.Change.IsZero() {
+		t.Fatalf("unexpected empty window %+v", s)
+	}
+}
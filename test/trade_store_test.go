@@ -0,0 +1,62 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "github.com/User/internal/pkg/TradeStore"
+)
+
+func TestTradeStorePagination(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.trades")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 5; i++ {
+		bid := "b-1"
+		if i%2 == 0 {
+			bid = "b-2"
+		}
+		store.Append(Trade{Symbol: "AA", AskOrderId: "a-1", BidOrderId: bid, BidAccountId: bid, Price: d(10), Quantity: d(1), Time: i})
+	}
+	store.Append(Trade{Symbol: "BB", Time: 6})
+	store.Close()
+
+	var memory Store = NewMemoryStore()
+	if store, err = OpenFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, trade := range store.Recent("AA", 10) {
+		memory.Append(trade)
+	}
+
+	for _, s := range []Store{store, memory} {
+		first, err := s.Query(Query{Symbol: "AA", Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first.Trades) != 2 || first.Trades[0].Time != 5 || first.NextCursor == "" {
+			t.Fatalf("unexpected first page %+v", first)
+		}
+		second, _ := s.Query(Query{Symbol: "AA", Limit: 2, Cursor: first.NextCursor})
+		if len(second.Trades) != 2 || second.Trades[0].Time != 3 {
+			t.Fatalf("unexpected second page %+v", second)
+		}
+		third, _ := s.Query(Query{Symbol: "AA", Limit: 2, Cursor: second.NextCursor})
+		if len(third.Trades) != 1 || third.NextCursor != "" {
+			t.Fatalf("unexpected last page %+v", third)
+		}
+
+		if page, _ := s.Query(Query{AccountId: "b-2"}); len(page.Trades) != 2 {
+			t.Fatalf("expected 2 trades of b-2, got %d", len(page.Trades))
+		}
+		if page, _ := s.Query(Query{Symbol: "AA", From: 2, To: 3}); len(page.Trades) != 2 {
+			t.Fatalf("expected 2 trades between 2 and 3, got %d", len(page.Trades))
+		}
+	}
+	if _, err := store.Query(Query{Cursor: "!"}); err != ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -0,0 +1,735 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/User/internal/pkg/wss"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// startHub runs hub as the global hub, serves it on an in-process server
+// and returns its websocket URL. The server also has /sse and /poll.
+func startHub(t testing.TB, hub *wss.Hub) string {
+	gin.SetMode(gin.ReleaseMode)
+	wss.HHub = hub
+	go wss.HHub.Run()
+
+	r := gin.New()
+	r.GET("/ws", wss.ServeWs)
+	r.GET("/sse", wss.ServeSSE)
+	r.GET("/poll", wss.ServePoll)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+func dialHub(t testing.TB, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// pending holds the messages of a frame not read yet: the hub batches
+// queued messages into one frame, separated by newlines.
+var pending = map[*websocket.Conn][][]byte{}
+
+func readJSON(t testing.TB, conn *websocket.Conn) map[string]interface{} {
+	if len(pending[conn]) == 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending[conn] = bytes.Split(frame, []byte{'\n'})
+	}
+	message := pending[conn][0]
+	pending[conn] = pending[conn][1:]
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(message, &v); err != nil {
+		t.Fatalf("%s: %v", message, err)
+	}
+	return v
+}
+
+func publish(topic, tag string, data interface{}) {
+	wss.HHub.Publish(wss.Message{Topic: topic, Tag: tag, Data: data})
+}
+
+func TestWsSubscriptions(t *testing.T) {
+	url := startHub(t, wss.NewHub(func(topic string) bool { return topic == "trade:AA" || topic == "depth:AA" }))
+	subscriber := dialHub(t, url)
+	other := dialHub(t, url)
+
+	subscriber.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA"}, "id": "1"})
+	if r := readJSON(t, subscriber); r["event"] != "subscribed" || r["id"] != "1" {
+		t.Fatalf("unexpected ack %v", r)
+	}
+	other.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"depth:AA", "nope:AA"}, "id": "2"})
+	if r := readJSON(t, other); r["event"] != "error" || r["code"] != "UNKNOWN_TOPIC" || r["id"] != "2" {
+		t.Fatalf("unexpected error reply %v", r)
+	}
+	other.WriteJSON(map[string]interface{}{"op": "ping", "id": "3"})
+	if r := readJSON(t, other); r["event"] != "pong" || r["id"] != "3" {
+		t.Fatalf("unexpected pong %v", r)
+	}
+	other.WriteMessage(websocket.TextMessage, []byte("not json"))
+	if r := readJSON(t, other); r["code"] != "BAD_REQUEST" {
+		t.Fatalf("unexpected bad request reply %v", r)
+	}
+
+	publish("depth:AA", "depth", 1)
+	publish("trade:AA", "trade", 2)
+	if r := readJSON(t, subscriber); r["topic"] != "trade:AA" || r["data"] != 2.0 {
+		t.Fatalf("unexpected message %v", r)
+	}
+
+	// the other client got no depth, as it is not subscribed, and no copy of
+	// the subscriber's requests
+	other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, message, err := other.ReadMessage(); err == nil {
+		t.Fatalf("unexpected message %s", message)
+	}
+
+	subscriber.WriteJSON(map[string]interface{}{"op": "unsubscribe", "topics": []string{"trade:AA"}, "id": "4"})
+	if r := readJSON(t, subscriber); r["event"] != "unsubscribed" {
+		t.Fatalf("unexpected ack %v", r)
+	}
+	publish("trade:AA", "trade", 3)
+	subscriber.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, message, err := subscriber.ReadMessage(); err == nil {
+		t.Fatalf("unexpected message after unsubscribe %s", message)
+	}
+}
+
+func TestWsPrivateChannels(t *testing.T) {
+	hub := wss.NewHub(func(string) bool { return false }, "orders")
+	hub.SetAuthenticator(func(login wss.Login) (string, error) {
+		if login.Token != "alice-token" {
+			return "", errors.New("bad token")
+		}
+		return "alice", nil
+	})
+	conn := dialHub(t, startHub(t, hub))
+
+	conn.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"orders"}, "id": "1"})
+	if r := readJSON(t, conn); r["code"] != "UNAUTHENTICATED" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	conn.WriteJSON(map[string]interface{}{"op": "login", "token": "bob-token", "id": "2"})
+	if r := readJSON(t, conn); r["code"] != "UNAUTHORIZED" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	conn.WriteJSON(map[string]interface{}{"op": "login", "token": "alice-token", "id": "3"})
+	if r := readJSON(t, conn); r["event"] != "login" || r["account"] != "alice" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	conn.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"orders"}, "id": "4"})
+	if r := readJSON(t, conn); r["event"] != "subscribed" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+
+	publish(wss.PrivateTopic("orders", "bob"), "order", "bob's")
+	publish(wss.PrivateTopic("orders", "alice"), "order", "alice's")
+	if r := readJSON(t, conn); r["data"] != "alice's" {
+		t.Fatalf("unexpected message %v", r)
+	}
+}
+
+func TestWsHandlers(t *testing.T) {
+	hub := wss.NewHub(func(string) bool { return false })
+	hub.SetAuthenticator(func(login wss.Login) (string, error) { return login.Token, nil })
+	hub.Handle("place_order", func(session wss.Session, request []byte) (interface{}, *wss.Error) {
+		var req struct {
+			Quantity string `json:"quantity"`
+		}
+		json.Unmarshal(request, &req)
+		if req.Quantity == "0" {
+			return nil, &wss.Error{Code: "INVALID_QUANTITY", Message: "quantity must be positive"}
+		}
+		return map[string]string{"account": session.AccountId, "quantity": req.Quantity}, nil
+	})
+	conn := dialHub(t, startHub(t, hub))
+
+	conn.WriteJSON(map[string]interface{}{"op": "place_order", "quantity": "1", "id": "c1"})
+	if r := readJSON(t, conn); r["code"] != "UNAUTHENTICATED" || r["id"] != "c1" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	conn.WriteJSON(map[string]interface{}{"op": "login", "token": "alice"})
+	readJSON(t, conn)
+
+	conn.WriteJSON(map[string]interface{}{"op": "place_order", "quantity": "2", "id": "c2"})
+	conn.WriteJSON(map[string]interface{}{"op": "place_order", "quantity": "0", "id": "c3"})
+	r := readJSON(t, conn)
+	data, _ := r["data"].(map[string]interface{})
+	if r["event"] != "place_order" || r["id"] != "c2" || data["account"] != "alice" || data["quantity"] != "2" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	if r := readJSON(t, conn); r["event"] != "error" || r["code"] != "INVALID_QUANTITY" || r["id"] != "c3" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	conn.WriteJSON(map[string]interface{}{"op": "fly", "id": "c4"})
+	if r := readJSON(t, conn); r["code"] != "UNKNOWN_OP" || r["id"] != "c4" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+}
+
+func TestWsSlowConsumer(t *testing.T) {
+	hub := wss.NewHub(func(string) bool { return true })
+	hub.SetClass(wss.Conflated, "depth")
+	hub.SetClass(wss.Droppable, "trade")
+	url := startHub(t, hub)
+
+	slow := dialHub(t, url)
+	slow.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"l3:AA", "trade:AA", "depth:AA"}})
+	readJSON(t, slow)
+	fast := dialHub(t, url)
+	fast.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"depth:AA"}})
+	readJSON(t, fast)
+	go func() {
+		for {
+			if _, _, err := fast.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// slow never reads, so once the socket buffers are full its queue only
+	// grows: trades are dropped, depth is conflated and l3 piles up until
+	// the client is disconnected. The hub must keep serving meanwhile.
+	payload := strings.Repeat("x", 16<<10)
+	var seen wss.QueueStats
+	disconnected := false
+	for i := 0; i < 20000 && !disconnected; i++ {
+		publish("l3:AA", "l3", map[string]interface{}{"seq": i, "payload": payload})
+		publish("trade:AA", "trade", i)
+		publish("depth:AA", "depth", i)
+		if i%100 != 0 {
+			continue
+		}
+		stats := hub.Stats()
+		disconnected = len(stats) == 1
+		for _, s := range stats {
+			if len(s.Topics) == 3 && s.Dropped > 0 {
+				seen = s.QueueStats
+			}
+		}
+	}
+	if !disconnected {
+		t.Fatal("slow client was not disconnected")
+	}
+	if seen.Dropped == 0 || seen.Conflated == 0 || seen.MaxQueued < 256 {
+		t.Fatalf("unexpected queue stats %+v", seen)
+	}
+
+	slow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		_, _, err := slow.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != wss.CloseSlowConsumer {
+			t.Fatalf("unexpected close %v", err)
+		}
+		break
+	}
+}
+
+func TestWsPrivateChannelNeverDropped(t *testing.T) {
+	// the public orders channel is droppable, the private one of the same
+	// name must not be
+	hub := wss.NewHub(func(topic string) bool { return topic == "orders:AA" }, "orders")
+	hub.SetClass(wss.Droppable, "orders")
+	hub.SetAuthenticator(func(login wss.Login) (string, error) { return "alice", nil })
+	slow := dialHub(t, startHub(t, hub))
+	slow.WriteJSON(map[string]interface{}{"op": "login", "token": "alice-token"})
+	readJSON(t, slow)
+	slow.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"orders"}})
+	readJSON(t, slow)
+
+	payload := strings.Repeat("x", 16<<10)
+	disconnected := false
+	for i := 0; i < 20000 && !disconnected; i++ {
+		publish(wss.PrivateTopic("orders", "alice"), "order", payload)
+		if i%100 != 0 {
+			continue
+		}
+		stats := hub.Stats()
+		disconnected = len(stats) == 0
+		for _, s := range stats {
+			if s.Dropped > 0 {
+				t.Fatalf("private messages were dropped: %+v", s.QueueStats)
+			}
+		}
+	}
+	if !disconnected {
+		t.Fatal("slow client was not disconnected")
+	}
+}
+
+func TestWsVersionDedup(t *testing.T) {
+	conn := dialHub(t, startHub(t, wss.NewHub(func(string) bool { return true })))
+	conn.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"depth:AA"}})
+	readJSON(t, conn)
+
+	for _, seq := range []uint64{1, 1, 2, 1, 0, 3} {
+		wss.HHub.Publish(wss.Message{Topic: "depth:AA", Tag: "depth", Version: seq, Data: seq})
+	}
+	for i, want := range []float64{1, 2, 0, 3} {
+		r := readJSON(t, conn)
+		if r["data"] != want || r["seq"] != float64(i+1) {
+			t.Fatalf("got %v, want data %v at seq %d", r, want, i+1)
+		}
+	}
+}
+
+// BenchmarkWsFanOut measures publishing one message to every client of an
+// in-process server and waiting until all of them have read it.
+func BenchmarkWsFanOut(b *testing.B) {
+	for _, clients := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			benchmarkFanOut(b, clients)
+		})
+	}
+}
+
+func benchmarkFanOut(b *testing.B, clients int) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	url := startHub(b, wss.NewHub(func(string) bool { return true }))
+
+	// the reader that completes an iteration signals done
+	var received, target int64
+	done := make(chan struct{}, 1)
+	for i := 0; i < clients; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			// every client takes two descriptors, see ulimit -n
+			b.Skipf("dialed %d of %d clients: %v", i, clients, err)
+		}
+		b.Cleanup(func() { conn.Close() })
+		conn.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA"}})
+		if _, _, err := conn.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+		go func() {
+			for {
+				_, frame, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				n := atomic.AddInt64(&received, int64(bytes.Count(frame, []byte{'\n'})+1))
+				if n == atomic.LoadInt64(&target) {
+					done <- struct{}{}
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 1; i <= b.N; i++ {
+		atomic.StoreInt64(&target, int64(i*clients))
+		publish("trade:AA", "trade", i)
+		<-done
+	}
+}
+
+func TestWsSnapshot(t *testing.T) {
+	hub := wss.NewHub(func(string) bool { return true })
+	hub.SetSnapshot(2, "trade")
+	url := startHub(t, hub)
+
+	// once watcher has read the trades, the hub has them
+	watcher := dialHub(t, url)
+	watcher.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA"}})
+	readJSON(t, watcher)
+	for i := 1; i <= 3; i++ {
+		publish("trade:AA", "trade", i)
+		readJSON(t, watcher)
+	}
+
+	conn := dialHub(t, url)
+	conn.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA", "depth:AA"}, "id": "1"})
+	if r := readJSON(t, conn); r["event"] != "subscribed" {
+		t.Fatalf("unexpected ack %v", r)
+	}
+	r := readJSON(t, conn)
+	data, _ := r["data"].([]interface{})
+	if r["tag"] != wss.TagSnapshot || r["topic"] != "trade:AA" || r["seq"] != float64(3) || len(data) != 2 || data[0] != float64(2) || data[1] != float64(3) {
+		t.Fatalf("unexpected snapshot %v", r)
+	}
+
+	publish("trade:AA", "trade", 4)
+	if r := readJSON(t, conn); r["tag"] != "trade" || r["seq"] != float64(4) || r["data"] != float64(4) {
+		t.Fatalf("unexpected trade %v", r)
+	}
+}
+
+func TestWsResume(t *testing.T) {
+	url := startHub(t, wss.NewHub(func(string) bool { return true }))
+	watcher := dialHub(t, url)
+	watcher.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA"}})
+	readJSON(t, watcher)
+	for i := 1; i <= 3; i++ {
+		publish("trade:AA", "trade", i)
+		readJSON(t, watcher)
+	}
+
+	conn := dialHub(t, url)
+	conn.WriteJSON(map[string]interface{}{"op": "resume", "topic": "trade:AA", "seq": 1, "id": "1"})
+	if r := readJSON(t, conn); r["event"] != "resumed" || r["seq"] != float64(1) || r["id"] != "1" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	publish("trade:AA", "trade", 4)
+	for seq := 2; seq <= 4; seq++ {
+		if r := readJSON(t, conn); r["seq"] != float64(seq) || r["data"] != float64(seq) {
+			t.Fatalf("got %v, want seq %d", r, seq)
+		}
+	}
+
+	// from the future, as after a restart
+	other := dialHub(t, url)
+	other.WriteJSON(map[string]interface{}{"op": "resume", "topic": "trade:AA", "seq": 10})
+	if r := readJSON(t, other); r["event"] != "snapshot_required" || r["seq"] != float64(4) {
+		t.Fatalf("unexpected reply %v", r)
+	}
+
+	// too old
+	for i := 5; i <= 600; i++ {
+		publish("trade:AA", "trade", i)
+		readJSON(t, watcher)
+	}
+	late := dialHub(t, url)
+	late.WriteJSON(map[string]interface{}{"op": "resume", "topic": "trade:AA", "seq": 4})
+	if r := readJSON(t, late); r["event"] != "snapshot_required" || r["seq"] != float64(600) {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	publish("trade:AA", "trade", 601)
+	if r := readJSON(t, late); r["seq"] != float64(601) {
+		t.Fatalf("resumed client not subscribed: %v", r)
+	}
+}
+
+// httpURL is the URL of path on the server of a websocket URL.
+func httpURL(url, path string) string {
+	return "http" + strings.TrimSuffix(strings.TrimPrefix(url, "ws"), "/ws") + path
+}
+
+// watchTrades publishes trades from..to on trade:AA and waits until the hub
+// has sent them.
+func watchTrades(t *testing.T, watcher *websocket.Conn, from, to int) {
+	for i := from; i <= to; i++ {
+		publish("trade:AA", "trade", i)
+		readJSON(t, watcher)
+	}
+}
+
+func TestSSE(t *testing.T) {
+	url := startHub(t, wss.NewHub(func(string) bool { return true }))
+	watcher := dialHub(t, url)
+	watcher.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA"}})
+	readJSON(t, watcher)
+	watchTrades(t, watcher, 1, 3)
+
+	req, _ := http.NewRequest("GET", httpURL(url, "/sse?topics=trade:AA"), nil)
+	req.Header.Set("Last-Event-ID", "trade:AA=1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	events := bufio.NewScanner(res.Body)
+	next := func() (id string, data map[string]interface{}) {
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data)
+			case line == "" && data != nil:
+				return id, data
+			}
+		}
+		t.Fatal("stream ended", events.Err())
+		return
+	}
+
+	if id, r := next(); r["event"] != "resumed" || id != "trade:AA=1" {
+		t.Fatalf("unexpected event %s %v", id, r)
+	}
+	publish("trade:AA", "trade", 4)
+	for seq := 2; seq <= 4; seq++ {
+		if id, r := next(); r["seq"] != float64(seq) || id != fmt.Sprintf("trade:AA=%d", seq) {
+			t.Fatalf("got %s %v, want seq %d", id, r, seq)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	url := startHub(t, wss.NewHub(func(string) bool { return true }))
+	watcher := dialHub(t, url)
+	watcher.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA"}})
+	readJSON(t, watcher)
+	watchTrades(t, watcher, 1, 3)
+
+	poll := func(cursor string) (messages []map[string]interface{}, next string) {
+		res, err := http.Get(httpURL(url, "/poll?topics=trade:AA&cursor="+cursor))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body struct {
+			Ok   bool
+			Data struct {
+				Messages []map[string]interface{}
+				Cursor   string
+			}
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		if !body.Ok {
+			t.Fatalf("poll failed: %+v", body)
+		}
+		return body.Data.Messages, body.Data.Cursor
+	}
+
+	messages, cursor := poll("trade:AA=2")
+	last := messages[len(messages)-1]
+	if messages[0]["event"] != "resumed" || last["seq"] != float64(3) || cursor != "trade:AA=3" {
+		t.Fatalf("unexpected poll %v %s", messages, cursor)
+	}
+
+	// a poll waits for the next message
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		publish("trade:AA", "trade", 4)
+	}()
+	for {
+		messages, cursor = poll(cursor)
+		if cursor == "trade:AA=4" {
+			break
+		}
+		if len(messages) != 1 || messages[0]["event"] != "resumed" {
+			t.Fatalf("unexpected poll %v %s", messages, cursor)
+		}
+	}
+	if last := messages[len(messages)-1]; last["data"] != float64(4) {
+		t.Fatalf("unexpected poll %v", messages)
+	}
+}
+
+func TestPollSubscribeCursor(t *testing.T) {
+	url := startHub(t, wss.NewHub(func(string) bool { return true }))
+	for i := 1; i <= 3; i++ {
+		publish("trade:AA", "trade", i)
+	}
+	for i := 1; i <= 5; i++ {
+		publish("trade:BB", "trade", i)
+	}
+
+	poll := func(cursor string) (messages []map[string]interface{}, next string) {
+		res, err := http.Get(httpURL(url, "/poll?topics=trade:AA,trade:BB&cursor="+cursor))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body struct {
+			Data struct {
+				Messages []map[string]interface{}
+				Cursor   string
+			}
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		return body.Data.Messages, body.Data.Cursor
+	}
+
+	// trade:BB sends nothing, but the cursor still has the seq it was
+	// subscribed at
+	messages, cursor := poll("trade:AA=2")
+	if cursor != "trade:AA=3,trade:BB=5" {
+		t.Fatalf("unexpected cursor %s after %v", cursor, messages)
+	}
+	for _, m := range messages {
+		if m["event"] == "subscribed" {
+			if seqs := m["seqs"].(map[string]interface{}); seqs["trade:BB"] != float64(5) {
+				t.Fatalf("unexpected reply %v", m)
+			}
+		}
+	}
+
+	// so the next poll gets the message published in between
+	publish("trade:BB", "trade", 6)
+	messages, cursor = poll(cursor)
+	if cursor != "trade:AA=3,trade:BB=6" {
+		t.Fatalf("unexpected cursor %s after %v", cursor, messages)
+	}
+	if last := messages[len(messages)-1]; last["topic"] != "trade:BB" || last["data"] != float64(6) {
+		t.Fatalf("unexpected poll %v", messages)
+	}
+}
+
+func TestWsEncodings(t *testing.T) {
+	hub := wss.NewHub(func(string) bool { return true })
+	hub.SetBinary("trade", func(topic string, seq uint64, data []byte) ([]byte, error) {
+		return append([]byte{0x02, byte(seq)}, data...), nil
+	})
+	url := startHub(t, hub)
+
+	read := func(conn *websocket.Conn) []byte {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		kind, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != websocket.BinaryMessage {
+			t.Fatalf("got a text frame %s", message)
+		}
+		return message
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{"msgpack"}}
+	packed, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packed.Close()
+	packed.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA"}})
+	read(packed)
+
+	dialer = websocket.Dialer{EnableCompression: true}
+	compact, res, err := dialer.Dial(url+"?encoding=binary", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compact.Close()
+	if ext := res.Header.Get("Sec-Websocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("compression not negotiated: %q", ext)
+	}
+	compact.WriteJSON(map[string]interface{}{"op": "subscribe", "topics": []string{"trade:AA", "depth:AA"}})
+	read(compact)
+
+	publish("trade:AA", "trade", 7)
+	want := []byte{0x84, 0xa4, 'd', 'a', 't', 'a', 0x07, 0xa3, 's', 'e', 'q', 0x01,
+		0xa3, 't', 'a', 'g', 0xa5, 't', 'r', 'a', 'd', 'e', 0xa5, 't', 'o', 'p', 'i', 'c', 0xa8, 't', 'r', 'a', 'd', 'e', ':', 'A', 'A'}
+	if got := read(packed); !bytes.Equal(got, want) {
+		t.Fatalf("msgpack got %x, want %x", got, want)
+	}
+	if got := read(compact); !bytes.Equal(got, []byte{0x02, 0x01, '7'}) {
+		t.Fatalf("binary got %x", got)
+	}
+	// channels without a layout are MessagePack
+	publish("depth:AA", "depth", 1)
+	if got := read(compact); got[0] != 0x84 {
+		t.Fatalf("binary fallback got %x", got)
+	}
+
+	if _, _, err := websocket.DefaultDialer.Dial(url+"?encoding=xml", nil); err == nil {
+		t.Fatal("unknown encoding accepted")
+	}
+}
+
+func TestWsSessions(t *testing.T) {
+	hub := wss.NewHub(func(string) bool { return false })
+	hub.SetAuthenticator(func(login wss.Login) (string, error) { return login.Token, nil })
+	handled := make(chan wss.Session, 1)
+	hub.Handle("whoami", func(session wss.Session, request []byte) (interface{}, *wss.Error) {
+		handled <- session
+		return nil, nil
+	})
+	disconnected := make(chan wss.Session, 1)
+	hub.OnDisconnect(func(session wss.Session) { disconnected <- session })
+	url := startHub(t, hub)
+
+	anonymous := dialHub(t, url)
+	anonymous.WriteJSON(map[string]interface{}{"op": "ping"})
+	readJSON(t, anonymous)
+	anonymous.Close()
+
+	conn := dialHub(t, url)
+	conn.WriteJSON(map[string]interface{}{"op": "login", "token": "alice"})
+	r := readJSON(t, conn)
+	id, _ := r["session"].(string)
+	if r["event"] != "login" || id == "" {
+		t.Fatalf("unexpected login reply %v", r)
+	}
+	conn.WriteJSON(map[string]interface{}{"op": "whoami"})
+	readJSON(t, conn)
+	if session := <-handled; session.Id != id || session.AccountId != "alice" {
+		t.Fatalf("handler got session %+v", session)
+	}
+
+	conn.Close()
+	select {
+	case session := <-disconnected:
+		if session.Id != id || session.AccountId != "alice" {
+			t.Fatalf("disconnect of session %+v", session)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("disconnect not reported")
+	}
+}
+
+func TestPollLogin(t *testing.T) {
+	hub := wss.NewHub(func(string) bool { return false }, "orders")
+	hub.SetAuthenticator(func(login wss.Login) (string, error) {
+		if login.Token != "alice-token" {
+			return "", errors.New("bad token")
+		}
+		return "alice", nil
+	})
+	url := startHub(t, hub)
+
+	poll := func(query string, header http.Header) map[string]interface{} {
+		req, _ := http.NewRequest("GET", httpURL(url, "/poll?topics=orders"+query), nil)
+		req.Header = header
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body struct {
+			Data struct {
+				Messages []map[string]interface{}
+			}
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		if len(body.Data.Messages) == 0 {
+			t.Fatal("no reply")
+		}
+		return body.Data.Messages[len(body.Data.Messages)-1]
+	}
+
+	// credentials in the URL are not used
+	if r := poll("&token=alice-token", http.Header{}); r["code"] != "UNAUTHENTICATED" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		publish(wss.PrivateTopic("orders", "alice"), "order", "alice's")
+	}()
+	if r := poll("", http.Header{"X-Token": {"alice-token"}}); r["data"] != "alice's" {
+		t.Fatalf("unexpected message %v", r)
+	}
+}